@@ -16,15 +16,24 @@ import (
 var (
 	nicoCookieName     = "user_session"
 	nicoCookieValueReg = regexp.MustCompile(`^user_session_\d+_[0-9a-f]{64}$`)
-	nicoGlobalURL, _   = url.Parse("http://nicovideo.jp")
 )
 
+var errNotLogin = errors.New("not login")
+
+// Account keeps the password only in memory; the account file holds it
+// sealed with a passphrase.
 type Account struct {
-	Mail     string `json:"mail"`
-	Password string `json:"password"`
-	Session  string `json:"session"`
+	Mail     string          `json:"mail"`
+	Password string          `json:"-"`
+	Sealed   *SealedPassword `json:"sealed_password,omitempty"`
+	Session  string          `json:"session"`
+
+	Endpoints Endpoints `json:"-"`
 }
 
+// LoadAccount also reads account files of older versions, which have the
+// password in plaintext. Such an account has Password set and Sealed nil
+// until it is sealed and saved again.
 func LoadAccount(path string) (*Account, error) {
 	f, err := os.Open(path)
 	if err != nil {
@@ -32,13 +41,48 @@ func LoadAccount(path string) (*Account, error) {
 	}
 	defer f.Close()
 
-	a := new(Account)
-	if err := json.NewDecoder(f).Decode(a); err != nil {
+	var file struct {
+		Account
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(f).Decode(&file); err != nil {
 		return nil, fmt.Errorf("failed to parse account file %v", path)
 	}
+
+	a := &file.Account
+	a.Password = file.Password
+	a.Endpoints = DefaultEndpoints
 	return a, nil
 }
 
+// IsPlaintext reports whether the password was loaded unsealed.
+func (a *Account) IsPlaintext() bool {
+	return a.Password != "" && a.Sealed == nil
+}
+
+// Seal encrypts Password with passphrase for the next SaveTo.
+func (a *Account) Seal(passphrase string) error {
+	sealed, err := sealPassword(a.Password, passphrase)
+	if err != nil {
+		return err
+	}
+	a.Sealed = sealed
+	return nil
+}
+
+// Unseal decrypts the saved password into Password.
+func (a *Account) Unseal(passphrase string) error {
+	if a.Sealed == nil {
+		return errors.New("no saved password")
+	}
+	password, err := a.Sealed.open(passphrase)
+	if err != nil {
+		return err
+	}
+	a.Password = password
+	return nil
+}
+
 func (a *Account) SaveTo(path string) error {
 	b, err := json.MarshalIndent(a, "", "	")
 	if err != nil {
@@ -55,9 +99,10 @@ func clientWithCookie() http.Client {
 
 func (a *Account) NewClient() http.Client {
 	client := clientWithCookie()
-	client.Jar.SetCookies(nicoGlobalURL, []*http.Cookie{
+	cookieURL := a.Endpoints.cookieURL()
+	client.Jar.SetCookies(cookieURL, []*http.Cookie{
 		&http.Cookie{
-			Domain: nicoGlobalURL.Host,
+			Domain: cookieURL.Hostname(),
 			Path:   "/",
 			Name:   nicoCookieName,
 			Value:  a.Session,
@@ -69,7 +114,7 @@ func (a *Account) NewClient() http.Client {
 
 func (a *Account) HeartBeat() error {
 	client := a.NewClient()
-	res, err := client.Get("http://live.nicovideo.jp/api/heartbeat")
+	res, err := client.Get(a.Endpoints.Live + "/api/heartbeat")
 	if err != nil {
 		return err
 	}
@@ -84,7 +129,7 @@ func (a *Account) HeartBeat() error {
 		return err
 	}
 	if h.Err.Code == "NOTLOGIN" {
-		return errors.New("not login")
+		return errNotLogin
 	}
 
 	return nil
@@ -93,7 +138,7 @@ func (a *Account) HeartBeat() error {
 func (a *Account) Login() error {
 	client := clientWithCookie()
 	_, err := client.PostForm(
-		"https://secure.nicovideo.jp/secure/login?site=nicolive",
+		a.Endpoints.Secure+"/secure/login?site=nicolive",
 		url.Values{
 			"mail":     {a.Mail},
 			"password": {a.Password},
@@ -103,7 +148,7 @@ func (a *Account) Login() error {
 		return err
 	}
 
-	for _, cookie := range client.Jar.Cookies(nicoGlobalURL) {
+	for _, cookie := range client.Jar.Cookies(a.Endpoints.cookieURL()) {
 		if cookie.Name == nicoCookieName && nicoCookieValueReg.MatchString(cookie.Value) {
 			a.Session = cookie.Value
 			return nil
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/kroton/kome/fakenico"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// testEndpoints points every API at s.
+func testEndpoints(s *fakenico.Server) Endpoints {
+	return Endpoints{Cookie: s.URL, Secure: s.URL, Live: s.URL, Watch: s.URL, UserAPI: s.URL}
+}
+
+func TestLogin(t *testing.T) {
+	s, err := fakenico.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	a := &Account{Mail: fakenico.Mail, Password: fakenico.Password, Endpoints: testEndpoints(s)}
+	if err := a.Login(); err != nil {
+		t.Fatal(err)
+	}
+	if a.Session != fakenico.Session {
+		t.Errorf("session = %q, want %q", a.Session, fakenico.Session)
+	}
+
+	wrong := &Account{Mail: fakenico.Mail, Password: "wrong", Endpoints: testEndpoints(s)}
+	if err := wrong.Login(); err == nil {
+		t.Error("login with a wrong password succeeded")
+	}
+}
+
+func TestHeartBeat(t *testing.T) {
+	s, err := fakenico.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	a := &Account{Session: fakenico.Session, Endpoints: testEndpoints(s)}
+	if err := a.HeartBeat(); err != nil {
+		t.Errorf("heartbeat with a valid session: %v", err)
+	}
+
+	a.Session = "user_session_expired"
+	if err := a.HeartBeat(); err != errNotLogin {
+		t.Errorf("heartbeat with an expired session = %v, want %v", err, errNotLogin)
+	}
+}
+
+func TestLoginAccountKeepsPlaintextWithoutTerminal(t *testing.T) {
+	s, err := fakenico.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// an account file of an older version whose session has expired
+	path := filepath.Join(t.TempDir(), "account.json")
+	old := `{"mail": "` + fakenico.Mail + `", "password": "` + fakenico.Password + `", "session": "user_session_expired"}`
+	if err := ioutil.WriteFile(path, []byte(old), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := LoadAccount(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Endpoints = testEndpoints(s)
+	if err := loginAccount(a, path, ""); err != nil {
+		t.Fatal(err)
+	}
+	if a.Session != fakenico.Session {
+		t.Errorf("session = %q, want %q", a.Session, fakenico.Session)
+	}
+
+	// left for the next interactive run to seal
+	a, err = LoadAccount(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !a.IsPlaintext() || a.Password != fakenico.Password {
+		t.Errorf("the plaintext password was not kept")
+	}
+}
+
+func TestSealPassword(t *testing.T) {
+	sealed, err := sealPassword("secret", "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if password, err := sealed.open("passphrase"); err != nil || password != "secret" {
+		t.Errorf("open = %q, %v, want %q", password, err, "secret")
+	}
+	if _, err := sealed.open("wrong"); err != errWrongPassphrase {
+		t.Errorf("open with a wrong passphrase = %v, want %v", err, errWrongPassphrase)
+	}
+
+	truncated := *sealed
+	truncated.Nonce = truncated.Nonce[:len(truncated.Nonce)-1]
+	if _, err := truncated.open("passphrase"); err == nil {
+		t.Error("a truncated nonce was accepted")
+	}
+}
+
+func TestLoadPlaintextAccount(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "account.json")
+	old := `{"mail": "mail@example.com", "password": "secret", "session": "session"}`
+	if err := ioutil.WriteFile(path, []byte(old), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := LoadAccount(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !a.IsPlaintext() || a.Password != "secret" || a.Session != "session" {
+		t.Errorf("loaded %+v", a)
+	}
+}
+
+func TestSaveToHasNoPassword(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "account.json")
+	a := &Account{Mail: "mail@example.com", Password: "secret", Session: "session"}
+
+	for _, seal := range []bool{false, true} {
+		if seal {
+			if err := a.Seal("passphrase"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := a.SaveTo(path); err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var keys map[string]json.RawMessage
+		if err := json.Unmarshal(b, &keys); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := keys["password"]; ok {
+			t.Errorf("sealed %v: account file has a password: %s", seal, b)
+		}
+		if _, ok := keys["sealed_password"]; ok != seal {
+			t.Errorf("sealed %v: account file is %s", seal, b)
+		}
+	}
+
+	a, err := LoadAccount(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Unseal("passphrase"); err != nil || a.Password != "secret" {
+		t.Errorf("unseal = %q, %v", a.Password, err)
+	}
+}
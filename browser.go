@@ -0,0 +1,90 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// cookie queries of the supported browsers; each selects value of the
+// session cookie for domain (e.g. "nicovideo.jp"), newest first
+var browserCookieQueries = map[string]string{
+	// Firefox cookies.sqlite
+	"moz_cookies": `select value, '' from moz_cookies
+		where name = ? and (host = ? or host like '%.' || ?)
+		order by expiry desc`,
+	// Chromium Cookies
+	"cookies": `select value, encrypted_value from cookies
+		where name = ? and (host_key = ? or host_key like '%.' || ?)
+		order by expires_utc desc`,
+}
+
+// SessionFromBrowser reads the session cookie from the cookie database of
+// Firefox or Chromium at path. Cookies encrypted by Chromium can't be read.
+func SessionFromBrowser(path string, e Endpoints) (string, error) {
+	// immutable lets us read the database while the browser holds its lock
+	db, err := sql.Open("sqlite3", "file:"+url.PathEscape(path)+"?mode=ro&immutable=1")
+	if err != nil {
+		return "", fmt.Errorf("failed to open cookie database %v", path)
+	}
+	defer db.Close()
+
+	query, err := browserCookieQuery(db)
+	if err != nil {
+		return "", fmt.Errorf("failed to read cookie database %v", path)
+	}
+	if query == "" {
+		return "", fmt.Errorf("unknown cookie database %v", path)
+	}
+
+	domain := e.cookieURL().Hostname()
+	rows, err := db.Query(query, nicoCookieName, domain, domain)
+	if err != nil {
+		return "", fmt.Errorf("failed to read cookie database %v", path)
+	}
+	defer rows.Close()
+
+	encrypted := false
+	for rows.Next() {
+		var value string
+		var encryptedValue []byte
+		if err := rows.Scan(&value, &encryptedValue); err != nil {
+			return "", err
+		}
+		if value == "" && len(encryptedValue) > 0 {
+			encrypted = true
+			continue
+		}
+		if nicoCookieValueReg.MatchString(value) {
+			return value, nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	if encrypted {
+		return "", errors.New("session cookie is encrypted by the browser")
+	}
+	return "", fmt.Errorf("no session cookie for %v in %v", domain, path)
+}
+
+func browserCookieQuery(db *sql.DB) (string, error) {
+	rows, err := db.Query(`select name from sqlite_master where type = 'table'`)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return "", err
+		}
+		if query, ok := browserCookieQueries[name]; ok {
+			return query, nil
+		}
+	}
+	return "", rows.Err()
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"database/sql"
+	"github.com/kroton/kome/fakenico"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	firefoxSchema  = `create table moz_cookies(id integer primary key, name text, value text, host text, path text, expiry integer)`
+	firefoxCookie  = `insert into moz_cookies(name, value, host, path, expiry) values(?, ?, ?, '/', ?)`
+	chromiumSchema = `create table cookies(host_key text, name text, value text, encrypted_value blob, path text, expires_utc integer)`
+	chromiumCookie = `insert into cookies(host_key, name, value, encrypted_value, path, expires_utc) values(?, ?, ?, ?, '/', ?)`
+
+	// newer than fakenico.Session but set for another site
+	otherSession = "user_session_2_fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210"
+)
+
+// cookieDB creates a cookie database in a temporary directory.
+func cookieDB(t *testing.T, schema string) (string, *sql.DB) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "cookies.sqlite")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.Exec(schema); err != nil {
+		t.Fatal(err)
+	}
+	return path, db
+}
+
+func mustExec(t *testing.T, db *sql.DB, query string, args ...interface{}) {
+	t.Helper()
+	if _, err := db.Exec(query, args...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSessionFromFirefox(t *testing.T) {
+	for _, host := range []string{".nicovideo.jp", "nicovideo.jp"} {
+		path, db := cookieDB(t, firefoxSchema)
+		mustExec(t, db, firefoxCookie, nicoCookieName, fakenico.Session, host, 2)
+		mustExec(t, db, firefoxCookie, nicoCookieName, otherSession, "evilnicovideo.jp", 3)
+		mustExec(t, db, firefoxCookie, "other", "value", host, 4)
+
+		session, err := SessionFromBrowser(path, DefaultEndpoints)
+		if err != nil {
+			t.Errorf("host %v: %v", host, err)
+		} else if session != fakenico.Session {
+			t.Errorf("host %v: session = %q, want %q", host, session, fakenico.Session)
+		}
+	}
+}
+
+func TestSessionFromChromium(t *testing.T) {
+	for _, host := range []string{".nicovideo.jp", "nicovideo.jp"} {
+		path, db := cookieDB(t, chromiumSchema)
+		mustExec(t, db, chromiumCookie, host, nicoCookieName, fakenico.Session, []byte{}, 2)
+		mustExec(t, db, chromiumCookie, ".example.com", nicoCookieName, otherSession, []byte{}, 3)
+
+		session, err := SessionFromBrowser(path, DefaultEndpoints)
+		if err != nil {
+			t.Errorf("host %v: %v", host, err)
+		} else if session != fakenico.Session {
+			t.Errorf("host %v: session = %q, want %q", host, session, fakenico.Session)
+		}
+	}
+}
+
+func TestSessionFromBrowserErrors(t *testing.T) {
+	path, db := cookieDB(t, chromiumSchema)
+	mustExec(t, db, chromiumCookie, ".nicovideo.jp", nicoCookieName, "", []byte("v10 encrypted"), 2)
+	if _, err := SessionFromBrowser(path, DefaultEndpoints); err == nil || !strings.Contains(err.Error(), "encrypted") {
+		t.Errorf("encrypted cookie: err = %v", err)
+	}
+
+	path, _ = cookieDB(t, `create table something(name text)`)
+	if _, err := SessionFromBrowser(path, DefaultEndpoints); err == nil || !strings.Contains(err.Error(), "unknown cookie database") {
+		t.Errorf("unknown schema: err = %v", err)
+	}
+
+	path, _ = cookieDB(t, firefoxSchema)
+	if _, err := SessionFromBrowser(path, DefaultEndpoints); err == nil || !strings.Contains(err.Error(), "no session cookie") {
+		t.Errorf("no cookie: err = %v", err)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const commentLogQueue = 1024
+
+type loggedChat struct {
+	liveID string
+	kome   Chat
+}
+
+// CommentLog stores every received comment so a broadcast can be
+// reopened with its history. Writes are batched on a goroutine.
+// Comments that could not be stored are reported on ErrCh.
+type CommentLog struct {
+	db *sql.DB
+	ch chan loggedChat
+	wg sync.WaitGroup
+
+	mu      sync.Mutex
+	dropped int
+
+	ErrCh chan error
+}
+
+func NewCommentLog(db *sql.DB) *CommentLog {
+	l := &CommentLog{
+		db:    db,
+		ch:    make(chan loggedChat, commentLogQueue),
+		ErrCh: make(chan error, 16),
+	}
+	l.wg.Add(1)
+	go l.writer()
+	return l
+}
+
+// Save never blocks; a comment is dropped if the database can't keep up.
+func (l *CommentLog) Save(liveID string, kome Chat) {
+	select {
+	case l.ch <- loggedChat{liveID, kome}:
+	default:
+		l.mu.Lock()
+		l.dropped++
+		l.mu.Unlock()
+	}
+}
+
+// Close flushes pending comments.
+func (l *CommentLog) Close() {
+	close(l.ch)
+	l.wg.Wait()
+}
+
+func (l *CommentLog) writer() {
+	defer l.wg.Done()
+
+	for c := range l.ch {
+		batch := []loggedChat{c}
+	drain:
+		for {
+			select {
+			case c, ok := <-l.ch:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, c)
+			default:
+				break drain
+			}
+		}
+		// a locked database is often free again right away
+		err := l.write(batch)
+		if err != nil {
+			err = l.write(batch)
+		}
+		if err != nil {
+			l.report(fmt.Errorf("failed to log %d comments: %v", len(batch), err))
+		}
+
+		l.mu.Lock()
+		dropped := l.dropped
+		l.dropped = 0
+		l.mu.Unlock()
+		if dropped > 0 {
+			l.report(fmt.Errorf("comment log is full, dropped %d comments", dropped))
+		}
+	}
+}
+
+func (l *CommentLog) report(err error) {
+	select {
+	case l.ErrCh <- err:
+	default:
+	}
+}
+
+func (l *CommentLog) write(batch []loggedChat) error {
+	tx, err := l.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, c := range batch {
+		k := c.kome
+		_, err := tx.Exec(
+			`insert or ignore into comment(live_id, no, thread, vpos, date, user_id, mail, premium, comment)
+			values(?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			c.liveID, k.No, k.Thread, k.Vpos, k.Date, k.UserID, k.Mail, k.Premium, k.Comment,
+		)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (l *CommentLog) Load(liveID string) ([]Chat, error) {
+	rows, err := l.db.Query(
+		`select no, thread, vpos, date, user_id, mail, premium, comment
+		from comment where live_id = ? order by no`,
+		liveID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var komes []Chat
+	for rows.Next() {
+		var k Chat
+		err := rows.Scan(&k.No, &k.Thread, &k.Vpos, &k.Date, &k.UserID, &k.Mail, &k.Premium, &k.Comment)
+		if err != nil {
+			return nil, err
+		}
+		komes = append(komes, k)
+	}
+	return komes, rows.Err()
+}
+
+// BroadcastSeen is when a user first commented in a broadcast.
+type BroadcastSeen struct {
+	LiveID string
+	First  time.Time
+}
+
+// UserHistory lists the broadcasts userID commented in, oldest first.
+func (l *CommentLog) UserHistory(userID string) ([]BroadcastSeen, error) {
+	rows, err := l.db.Query(
+		`select live_id, min(date) from comment where user_id = ?
+		group by live_id order by min(date)`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var seen []BroadcastSeen
+	for rows.Next() {
+		var s BroadcastSeen
+		var date int64
+		if err := rows.Scan(&s.LiveID, &date); err != nil {
+			return nil, err
+		}
+		s.First = time.Unix(date, 0)
+		seen = append(seen, s)
+	}
+	return seen, rows.Err()
+}
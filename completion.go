@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"github.com/nsf/termbox-go"
+	"strings"
+)
+
+const maxCandidates = 20
+
+// commandNames are completed after ':'; keep them in sync with execCommand.
+var commandNames = []string{
+	"q", "184", "send", "cancel", "open", "filter", "nofilter", "rename",
+	"ng", "ngword", "ngregex", "ngdel", "ngmode",
+}
+
+// commandArgs are completed for the first argument of a command.
+var commandArgs = map[string][]string{
+	"filter": {"184", "raw", "user"},
+	"send": {
+		"184", "ue", "shita", "big", "small",
+		"white", "red", "pink", "orange", "yellow", "green", "cyan", "blue", "purple", "black",
+	},
+	"ngdel":  {"user", "word", "regex"},
+	"ngmode": {"hide", "mask"},
+}
+
+// completing reports whether Tab was pressed and the candidates are shown.
+func (e *lineEditor) completing() bool {
+	return e.cands != nil
+}
+
+// wordBeforeCursor returns where the word being typed starts and the word.
+func (e *lineEditor) wordBeforeCursor() (int, string) {
+	from := e.pos
+	for from > 0 && e.buf[from-1] != ' ' {
+		from--
+	}
+	return from, string(e.buf[from:e.pos])
+}
+
+// complete replaces the word from from with the first of cands.
+// Pressing Tab again goes through the rest with nextCandidate.
+func (e *lineEditor) complete(from int, cands []string) {
+	e.cands = cands
+	e.candIdx = -1
+	e.candFrom = from
+	e.nextCandidate()
+}
+
+func (e *lineEditor) nextCandidate() {
+	e.candIdx = (e.candIdx + 1) % len(e.cands)
+	cand := []rune(e.cands[e.candIdx])
+
+	rest := append([]rune(nil), e.buf[e.pos:]...)
+	e.buf = append(append(e.buf[:e.candFrom], cand...), rest...)
+	e.pos = e.candFrom + len(cand)
+}
+
+func (v *View) complete() {
+	if v.edit.completing() {
+		v.edit.nextCandidate()
+		return
+	}
+
+	from, word := v.edit.wordBeforeCursor()
+	var cands []string
+	switch v.edit.mode {
+	case ':':
+		before := strings.Fields(string(v.edit.buf[:from]))
+		switch len(before) {
+		case 0:
+			cands = withPrefix(commandNames, word)
+		case 1:
+			cands = withPrefix(commandArgs[before[0]], word)
+		}
+	case 'i':
+		if strings.HasPrefix(word, ">>") {
+			cands = v.anchorCandidates(word)
+		} else {
+			cands = v.userCandidates(word)
+		}
+	}
+
+	if len(cands) == 0 {
+		return
+	}
+	v.edit.complete(from, cands)
+}
+
+func withPrefix(words []string, prefix string) []string {
+	var cands []string
+	for _, w := range words {
+		if strings.HasPrefix(w, prefix) {
+			cands = append(cands, w)
+		}
+	}
+	return cands
+}
+
+// anchorCandidates returns >>No for recent comments, newest first.
+func (v *View) anchorCandidates(word string) []string {
+	var cands []string
+	for i := len(v.komes) - 1; i >= 0 && len(cands) < maxCandidates; i-- {
+		anchor := fmt.Sprintf(">>%d", v.komes[i].No)
+		if strings.HasPrefix(anchor, word) {
+			cands = append(cands, anchor)
+		}
+	}
+	return cands
+}
+
+// userCandidates returns names of users seen in this broadcast, latest speaker first.
+func (v *View) userCandidates(word string) []string {
+	word = strings.ToLower(word)
+	seen := make(map[string]bool)
+
+	var cands []string
+	for i := len(v.komes) - 1; i >= 0 && len(cands) < maxCandidates; i-- {
+		u := v.komes[i].User
+		if seen[u.Name] || !u.IsRawUser && u.Name == "184" {
+			continue
+		}
+		seen[u.Name] = true
+		if strings.HasPrefix(strings.ToLower(u.Name), word) {
+			cands = append(cands, u.Name)
+		}
+	}
+	return cands
+}
+
+// drawCandidates shows the candidates on row y with the current one highlighted.
+func (v *View) drawCandidates(y int) {
+	x := 0
+	for i, cand := range v.edit.cands {
+		fg, bg := termbox.ColorDefault, v.conf.Theme.StatusBar
+		if i == v.edit.candIdx {
+			fg, bg = termbox.ColorBlack, termbox.ColorYellow
+		}
+		for _, c := range cand {
+			if x+width(c) > v.width {
+				break
+			}
+			termbox.SetCell(x, y, c, fg, bg)
+			x += width(c)
+		}
+		if x < v.width {
+			termbox.SetCell(x, y, ' ', termbox.ColorDefault, v.conf.Theme.StatusBar)
+			x++
+		}
+	}
+	for ; x < v.width; x++ {
+		termbox.SetCell(x, y, ' ', termbox.ColorDefault, v.conf.Theme.StatusBar)
+	}
+}
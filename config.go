@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/nsf/termbox-go"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// actions that can be bound to a key sequence in config.json
+var defaultKeys = map[string]string{
+	"down":        "j",
+	"up":          "k",
+	"top":         "gg",
+	"bottom":      "G",
+	"insert":      "i",
+	"command":     ":",
+	"search":      "/",
+	"search_back": "?",
+	"next":        "n",
+	"prev":        "N",
+	"quit":        "q",
+	"wrap":        "w",
+	"left":        "h",
+	"right":       "l",
+	"detail":      "o",
+	"pause":       "p",
+	"speed":       "s",
+	"hooks":       "H",
+	"next_tab":    "gt",
+	"prev_tab":    "gT",
+}
+
+var colorNames = map[string]termbox.Attribute{
+	"default": termbox.ColorDefault,
+	"black":   termbox.ColorBlack,
+	"red":     termbox.ColorRed,
+	"green":   termbox.ColorGreen,
+	"yellow":  termbox.ColorYellow,
+	"blue":    termbox.ColorBlue,
+	"magenta": termbox.ColorMagenta,
+	"cyan":    termbox.ColorCyan,
+	"white":   termbox.ColorWhite,
+}
+
+type themeConfig struct {
+	No        string `json:"no"`
+	Time      string `json:"time"`
+	User      string `json:"user"`
+	User184   string `json:"user_184"`
+	Selection string `json:"selection"`
+	StatusBar string `json:"status_bar"`
+}
+
+var defaultTheme = themeConfig{
+	No:        "blue",
+	Time:      "yellow",
+	User:      "green",
+	User184:   "yellow",
+	Selection: "green",
+	StatusBar: "blue",
+}
+
+// Theme colors are foregrounds, except Selection and StatusBar, which are backgrounds.
+type Theme struct {
+	No        termbox.Attribute
+	Time      termbox.Attribute
+	User      termbox.Attribute
+	User184   termbox.Attribute
+	Selection termbox.Attribute
+	StatusBar termbox.Attribute
+}
+
+// OverlayConfig enables the overlay server when Port is set.
+// A relative CSS path is relative to the directory of config.json.
+type OverlayConfig struct {
+	Port int    `json:"port"`
+	CSS  string `json:"css"`
+}
+
+type Config struct {
+	Theme   Theme
+	Overlay OverlayConfig
+	Hooks   []HookConfig
+
+	// SendInterval is the least time between two posted comments.
+	SendInterval time.Duration
+
+	bindings map[string]string // key sequence -> action
+	seqs     []string          // key sequences, longest first
+}
+
+// LoadConfig returns the defaults if path does not exist.
+// Errors point at the line of config.json they come from.
+func LoadConfig(path string) (*Config, error) {
+	var raw struct {
+		Keys    map[string]string `json:"keys"`
+		Theme   themeConfig       `json:"theme"`
+		Overlay OverlayConfig     `json:"overlay"`
+		Hooks   []HookConfig      `json:"hooks"`
+
+		SendInterval *float64 `json:"send_interval"`
+	}
+	raw.Theme = defaultTheme
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to open config file %v", path)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return nil, configError(path, data, err)
+		}
+	}
+
+	c := &Config{Overlay: raw.Overlay, Hooks: raw.Hooks, bindings: make(map[string]string)}
+	if c.Overlay.CSS != "" && !filepath.IsAbs(c.Overlay.CSS) {
+		c.Overlay.CSS = filepath.Join(filepath.Dir(path), c.Overlay.CSS)
+	}
+	if err := validateHooks(c.Hooks); err != nil {
+		return nil, fmt.Errorf("%v:%d: %v", path, lineOf(data, "hooks"), err)
+	}
+	c.SendInterval = defaultSendInterval
+	if raw.SendInterval != nil {
+		if *raw.SendInterval < 0 {
+			return nil, fmt.Errorf("%v:%d: negative send_interval", path, lineOf(data, "send_interval"))
+		}
+		c.SendInterval = time.Duration(*raw.SendInterval * float64(time.Second))
+	}
+
+	keys := make(map[string]string)
+	for action, seq := range defaultKeys {
+		keys[action] = seq
+	}
+	for action, seq := range raw.Keys {
+		if _, ok := defaultKeys[action]; !ok {
+			return nil, fmt.Errorf("%v:%d: unknown action %q", path, lineOf(data, action), action)
+		}
+		if seq == "" {
+			return nil, fmt.Errorf("%v:%d: empty key for %q", path, lineOf(data, action), action)
+		}
+		keys[action] = seq
+	}
+	for action, seq := range keys {
+		if other, ok := c.bindings[seq]; ok {
+			return nil, fmt.Errorf("%v:%d: %q is bound to both %q and %q", path, lineOf(data, seq), seq, other, action)
+		}
+		c.bindings[seq] = action
+		c.seqs = append(c.seqs, seq)
+	}
+	sort.Slice(c.seqs, func(i, j int) bool { return len(c.seqs[i]) > len(c.seqs[j]) })
+
+	colors := []struct {
+		name string
+		attr *termbox.Attribute
+	}{
+		{raw.Theme.No, &c.Theme.No},
+		{raw.Theme.Time, &c.Theme.Time},
+		{raw.Theme.User, &c.Theme.User},
+		{raw.Theme.User184, &c.Theme.User184},
+		{raw.Theme.Selection, &c.Theme.Selection},
+		{raw.Theme.StatusBar, &c.Theme.StatusBar},
+	}
+	for _, col := range colors {
+		attr, err := parseColor(col.name)
+		if err != nil {
+			return nil, fmt.Errorf("%v:%d: %v", path, lineOf(data, col.name), err)
+		}
+		*col.attr = attr
+	}
+
+	return c, nil
+}
+
+// parseColor understands a color name optionally followed by "bold" or "underline".
+func parseColor(s string) (termbox.Attribute, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("empty color")
+	}
+
+	attr, ok := colorNames[fields[0]]
+	if !ok {
+		return 0, fmt.Errorf("unknown color %q", fields[0])
+	}
+	for _, f := range fields[1:] {
+		switch f {
+		case "bold":
+			attr |= termbox.AttrBold
+		case "underline":
+			attr |= termbox.AttrUnderline
+		default:
+			return 0, fmt.Errorf("unknown attribute %q", f)
+		}
+	}
+	return attr, nil
+}
+
+// lookup finds the action bound to the end of chain.
+// Digits typed before it are returned as count, 0 if there are none.
+func (c *Config) lookup(chain []rune) (string, int) {
+	s := string(chain)
+	for _, seq := range c.seqs {
+		if !strings.HasSuffix(s, seq) {
+			continue
+		}
+		count, err := strconv.Atoi(s[0 : len(s)-len(seq)])
+		if err != nil {
+			count = 0
+		}
+		return c.bindings[seq], count
+	}
+	return "", 0
+}
+
+func configError(path string, data []byte, err error) error {
+	var offset int64
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		offset = e.Offset
+	case *json.UnmarshalTypeError:
+		offset = e.Offset
+	default:
+		return fmt.Errorf("failed to parse config file %v: %v", path, err)
+	}
+	line := bytes.Count(data[0:offset], []byte("\n")) + 1
+	return fmt.Errorf("%v:%d: %v", path, line, err)
+}
+
+// lineOf returns the line where the JSON string s first appears, or 0.
+func lineOf(data []byte, s string) int {
+	quoted, _ := json.Marshal(s)
+	p := bytes.Index(data, quoted)
+	if p < 0 {
+		return 0
+	}
+	return bytes.Count(data[0:p], []byte("\n")) + 1
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+const (
+	ControlHB         = "hb"
+	ControlDisconnect = "disconnect"
+	ControlVote       = "vote"
+	ControlPerm       = "perm"
+	ControlInfo       = "info"
+)
+
+// Control is a command such as /disconnect sent by the operator or the broadcaster.
+// Text is what View shows in place of the raw comment.
+type Control struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+	Text    string   `json:"text"`
+}
+
+var htmlTagReg = regexp.MustCompile(`<[^>]*>`)
+
+// IsOperator reports whether kome comes from the system or the broadcaster.
+func (kome Chat) IsOperator() bool {
+	return kome.Premium&2 != 0
+}
+
+// parseControl returns nil if comment is not a control command.
+func parseControl(comment string) *Control {
+	if !strings.HasPrefix(comment, "/") {
+		return nil
+	}
+
+	fields := splitArgs(comment[1:])
+	if len(fields) == 0 {
+		return nil
+	}
+
+	c := &Control{
+		Command: fields[0],
+		Args:    fields[1:],
+	}
+	switch c.Command {
+	case ControlHB:
+		c.Text = "[hb] " + strings.Join(c.Args, " ")
+	case ControlDisconnect:
+		c.Text = "broadcast ended"
+	case ControlVote:
+		c.Text = voteText(c.Args)
+	case ControlPerm:
+		c.Text = "[perm] " + htmlTagReg.ReplaceAllString(strings.TrimSpace(comment[len("/perm"):]), "")
+	case ControlInfo:
+		text := c.Args
+		if len(text) > 0 {
+			text = text[1:] // info type
+		}
+		c.Text = "[info] " + strings.Join(text, " ")
+	default:
+		c.Text = comment
+	}
+	return c
+}
+
+func voteText(args []string) string {
+	if len(args) == 0 {
+		return "[vote]"
+	}
+	switch args[0] {
+	case "start":
+		if len(args) < 2 {
+			return "[vote] started"
+		}
+		return "[vote] " + args[1] + " (" + strings.Join(args[2:], " / ") + ")"
+	case "showresult":
+		return "[vote] result: " + strings.Join(args[1:], " / ")
+	case "stop":
+		return "[vote] closed"
+	}
+	return "[vote] " + strings.Join(args, " ")
+}
+
+// splitArgs splits s on spaces, keeping double-quoted parts together.
+func splitArgs(s string) []string {
+	var args []string
+	var cur []rune
+	quoted, inArg := false, false
+	for _, c := range s {
+		switch {
+		case c == '"':
+			quoted = !quoted
+			inArg = true
+		case c == ' ' && !quoted:
+			if inArg {
+				args = append(args, string(cur))
+				cur = cur[:0]
+				inArg = false
+			}
+		default:
+			cur = append(cur, c)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, string(cur))
+	}
+	return args
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"golang.org/x/crypto/scrypt"
+)
+
+// scrypt parameters for deriving the key that seals a password
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	sealKeyLen   = 32
+	sealSaltSize = 16
+)
+
+var errWrongPassphrase = errors.New("wrong passphrase")
+
+// SealedPassword is a password encrypted with AES-GCM under a key derived
+// from a passphrase, as stored in the account file.
+type SealedPassword struct {
+	Salt  []byte `json:"salt"`
+	Nonce []byte `json:"nonce"`
+	Data  []byte `json:"data"`
+}
+
+func sealKey(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, sealKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func sealPassword(password, passphrase string) (*SealedPassword, error) {
+	salt := make([]byte, sealSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := sealKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return &SealedPassword{
+		Salt:  salt,
+		Nonce: nonce,
+		Data:  aead.Seal(nil, nonce, []byte(password), nil),
+	}, nil
+}
+
+func (s *SealedPassword) open(passphrase string) (string, error) {
+	aead, err := sealKey(passphrase, s.Salt)
+	if err != nil {
+		return "", err
+	}
+	if len(s.Nonce) != aead.NonceSize() {
+		return "", errors.New("broken sealed password")
+	}
+	b, err := aead.Open(nil, s.Nonce, s.Data, nil)
+	if err != nil {
+		return "", errWrongPassphrase
+	}
+	return string(b), nil
+}
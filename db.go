@@ -0,0 +1,83 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const createVersionTable = `create table if not exists schema_version(version integer not null)`
+
+// migrations are applied in order, each one at most once.
+// Never edit a released entry; append a new one instead.
+var migrations = []string{
+	`create table if not exists user(id integer primary key, name varchar(255))`,
+	`alter table user add column fetched_at integer not null default 0`,
+	`create table if not exists comment(
+		live_id varchar(32) not null,
+		no integer not null,
+		thread integer not null,
+		vpos integer not null,
+		date integer not null,
+		user_id varchar(64) not null,
+		mail varchar(255) not null,
+		premium integer not null,
+		comment text not null,
+		primary key(live_id, no)
+	)`,
+	`create table if not exists ng(
+		kind varchar(16) not null,
+		value text not null,
+		primary key(kind, value)
+	)`,
+	`create table if not exists kotehan(user_id varchar(64) primary key, name varchar(255) not null)`,
+}
+
+func OpenWithMigrate(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open user database %v", path)
+	}
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate user database %v: %v", path, err)
+	}
+	return db, nil
+}
+
+func schemaVersion(db *sql.DB) (int, error) {
+	if _, err := db.Exec(createVersionTable); err != nil {
+		return 0, err
+	}
+	var version sql.NullInt64
+	if err := db.QueryRow("select max(version) from schema_version").Scan(&version); err != nil {
+		return 0, err
+	}
+	return int(version.Int64), nil
+}
+
+func migrate(db *sql.DB) error {
+	version, err := schemaVersion(db)
+	if err != nil {
+		return err
+	}
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %v", i+1, err)
+		}
+		if _, err := tx.Exec("insert into schema_version values(?)", i+1); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+func currentVersion(t *testing.T, db *sql.DB) int {
+	t.Helper()
+	version, err := schemaVersion(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return version
+}
+
+func TestMigrateBaseline(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "user.sqlite")
+
+	// the user table as kome created it before schema_version existed
+	old, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := old.Exec(`create table if not exists user(id integer primary key, name varchar(255))`); err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range []User{{ID: 1, Name: "one"}, {ID: 2, Name: "two"}} {
+		if _, err := old.Exec("insert into user values(?, ?)", u.ID, u.Name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old.Close()
+
+	db, err := OpenWithMigrate(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if v := currentVersion(t, db); v != len(migrations) {
+		t.Errorf("schema_version = %d, want %d", v, len(migrations))
+	}
+	r := &UserRepo{db: db}
+	for _, want := range []User{{ID: 1, Name: "one"}, {ID: 2, Name: "two"}} {
+		u, _, err := r.readFromDB(want.ID)
+		if err != nil {
+			t.Fatalf("user %d: %v", want.ID, err)
+		}
+		if u != want {
+			t.Errorf("user %d = %+v, want %+v", want.ID, u, want)
+		}
+	}
+}
+
+func TestMigrateFresh(t *testing.T) {
+	db, err := OpenWithMigrate(filepath.Join(t.TempDir(), "user.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if v := currentVersion(t, db); v != len(migrations) {
+		t.Errorf("schema_version = %d, want %d", v, len(migrations))
+	}
+	for _, table := range []string{"user", "comment", "ng", "kotehan"} {
+		var name string
+		err := db.QueryRow("select name from sqlite_master where type = 'table' and name = ?", table).Scan(&name)
+		if err != nil {
+			t.Errorf("table %v: %v", table, err)
+		}
+	}
+}
+
+func TestMigrateTwice(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "user.sqlite")
+	db, err := OpenWithMigrate(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db, err = OpenWithMigrate(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var rows int
+	if err := db.QueryRow("select count(*) from schema_version").Scan(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if v := currentVersion(t, db); v != len(migrations) || rows != len(migrations) {
+		t.Errorf("schema_version = %d in %d rows, want %d", v, rows, len(migrations))
+	}
+}
+
+func TestUserUpsert(t *testing.T) {
+	db, err := OpenWithMigrate(filepath.Join(t.TempDir(), "user.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	r := &UserRepo{db: db}
+	for _, name := range []string{"before", "after"} {
+		want := User{ID: 10, Name: name}
+		if err := r.writeToDB(want); err != nil {
+			t.Fatal(err)
+		}
+		u, fetchedAt, err := r.readFromDB(want.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u != want {
+			t.Errorf("read %+v, want %+v", u, want)
+		}
+		if fetchedAt.Unix() == 0 {
+			t.Errorf("fetched_at is not set")
+		}
+	}
+}
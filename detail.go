@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"github.com/nsf/termbox-go"
+	"strings"
+	"time"
+)
+
+// detailHeight is the number of rows the detail pane takes, separator included.
+const detailHeight = 12
+
+var premiumNames = map[int]string{
+	0: "normal",
+	1: "premium",
+	2: "system",
+	3: "broadcaster",
+}
+
+// detailShown reports whether the detail pane is open and fits on the screen.
+func (v *View) detailShown() bool {
+	return v.detail && v.height-2-detailHeight >= 1
+}
+
+// userHistory is cached per user, so the database is not queried on every redraw.
+func (v *View) userHistory(userID string) ([]BroadcastSeen, error) {
+	if v.log == nil {
+		return nil, nil
+	}
+	if v.historyFor != userID || v.historyErr != nil {
+		v.history, v.historyErr = v.log.UserHistory(userID)
+		v.historyFor = userID
+	}
+	return v.history, v.historyErr
+}
+
+func (v *View) detailLines() []string {
+	if len(v.shown) == 0 {
+		return []string{"no comment selected"}
+	}
+	kome := v.komes[v.shown[v.ptr]]
+
+	premium, ok := premiumNames[kome.Premium]
+	if !ok {
+		premium = "other"
+	}
+	kind := "raw"
+	if !kome.User.IsRawUser {
+		kind = "184"
+	}
+	count := 0
+	for _, k := range v.komes {
+		if k.UserID == kome.UserID {
+			count++
+		}
+	}
+
+	lines := []string{
+		fmt.Sprintf("No.        %d", kome.No),
+		fmt.Sprintf("Date       %s (%s)", time.Unix(kome.Date, 0).Format("2006-01-02 15:04:05"), v.elapsed(kome)),
+		fmt.Sprintf("Vpos       %d", kome.Vpos),
+		fmt.Sprintf("Mail       %s", kome.Mail),
+		fmt.Sprintf("Premium    %d (%s)", kome.Premium, premium),
+		fmt.Sprintf("User       %s %s (%s)", kome.UserID, kome.User.Name, kind),
+		fmt.Sprintf("Comments   %d in this broadcast", count),
+	}
+
+	seen, err := v.userHistory(kome.UserID)
+	if err != nil {
+		return append(lines, "History    "+err.Error())
+	}
+	if len(seen) > 0 {
+		lines = append(lines, fmt.Sprintf("First seen %s in %s", seen[0].First.Format("2006-01-02 15:04"), seen[0].LiveID))
+	}
+	var others []string
+	for _, s := range seen {
+		if s.LiveID != v.live.LiveID {
+			others = append(others, s.LiveID)
+		}
+	}
+	if len(others) > 0 {
+		lines = append(lines, "Also in    "+strings.Join(others, ", "))
+	}
+	return lines
+}
+
+// drawDetail draws the pane on the detailHeight rows from y.
+func (v *View) drawDetail(y int) {
+	for x := 0; x < v.width; x++ {
+		termbox.SetCell(x, y, '─', v.conf.Theme.StatusBar, termbox.ColorDefault)
+	}
+	y++
+
+	lines := v.detailLines()
+	for i := 0; i < detailHeight-1; i++ {
+		x := 0
+		if i < len(lines) {
+			for _, c := range lines[i] {
+				if x+width(c) > v.width {
+					break
+				}
+				termbox.SetCell(x, y, c, termbox.ColorDefault, termbox.ColorDefault)
+				x += width(c)
+			}
+		}
+		for ; x < v.width; x++ {
+			termbox.SetCell(x, y, ' ', termbox.ColorDefault, termbox.ColorDefault)
+		}
+		y++
+	}
+}
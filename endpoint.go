@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/url"
+)
+
+// Endpoints holds the base URLs of every nicovideo service kome talks to.
+// The message server address comes from getplayerstatus.
+type Endpoints struct {
+	Cookie  string // site the session cookie is set for
+	Secure  string // login
+	Live    string // heartbeat, getpostkey
+	Watch   string // getplayerstatus
+	UserAPI string // user.info
+}
+
+var DefaultEndpoints = Endpoints{
+	Cookie:  "http://nicovideo.jp",
+	Secure:  "https://secure.nicovideo.jp",
+	Live:    "http://live.nicovideo.jp",
+	Watch:   "http://watch.live.nicovideo.jp",
+	UserAPI: "http://api.ce.nicovideo.jp",
+}
+
+func (e Endpoints) cookieURL() *url.URL {
+	u, err := url.Parse(e.Cookie)
+	if err != nil {
+		return &url.URL{}
+	}
+	return u
+}
@@ -0,0 +1,346 @@
+// Package fakenico serves just enough of the nicovideo HTTP APIs and the
+// live message server protocol for kome to run against it locally.
+package fakenico
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	Mail     = "mail@example.com"
+	Password = "password"
+	Session  = "user_session_1_0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	UserID   = "1"
+	PostKey  = "postkey"
+	Ticket   = "ticket"
+	ThreadID = 1000
+)
+
+type chat struct {
+	XMLName xml.Name `xml:"chat"`
+	Thread  int64    `xml:"thread,attr"`
+	No      int      `xml:"no,attr"`
+	Vpos    int64    `xml:"vpos,attr"`
+	Date    int64    `xml:"date,attr"`
+	UserID  string   `xml:"user_id,attr"`
+	Premium int      `xml:"premium,attr"`
+	Mail    string   `xml:"mail,attr,omitempty"`
+	Ticket  string   `xml:"ticket,attr,omitempty"`
+	PostKey string   `xml:"postkey,attr,omitempty"`
+	Comment string   `xml:",innerxml"`
+}
+
+type threadReq struct {
+	Thread  int64 `xml:"thread,attr"`
+	ResFrom int   `xml:"res_from,attr"`
+}
+
+// Server is a fake nicovideo. HTTP endpoints are served from URL and
+// the message server listens on the address returned by getplayerstatus.
+type Server struct {
+	URL string
+
+	http *httptest.Server
+	ms   net.Listener
+
+	StartTime int64
+
+	mu        sync.Mutex
+	expireKey bool
+	users     map[int64]string
+	chats     []chat
+	clients   map[net.Conn]bool
+	wg        sync.WaitGroup
+}
+
+func NewServer() (*Server, error) {
+	ms, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		ms:        ms,
+		StartTime: time.Now().Unix(),
+		users:     make(map[int64]string),
+		clients:   make(map[net.Conn]bool),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/secure/login", s.login)
+	mux.HandleFunc("/api/heartbeat", s.heartbeat)
+	mux.HandleFunc("/api/getplayerstatus", s.playerStatus)
+	mux.HandleFunc("/api/getpostkey", s.postKey)
+	mux.HandleFunc("/api/v1/user.info", s.userInfo)
+	s.http = httptest.NewServer(mux)
+	s.URL = s.http.URL
+
+	s.wg.Add(1)
+	go s.accept()
+	return s, nil
+}
+
+func (s *Server) Close() {
+	s.http.Close()
+	s.ms.Close()
+	s.DropConnections()
+	s.wg.Wait()
+}
+
+// AddUser makes id known to user.info.
+func (s *Server) AddUser(id int64, name string) {
+	s.mu.Lock()
+	s.users[id] = name
+	s.mu.Unlock()
+}
+
+// Post sends a comment from userID to every connected client
+// and returns its number.
+func (s *Server) Post(userID, comment string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := chat{
+		Thread:  ThreadID,
+		UserID:  userID,
+		Date:    time.Now().Unix(),
+		Comment: html.EscapeString(comment),
+	}
+	return s.post(c)
+}
+
+// End sends the broadcaster's /disconnect, which ends the broadcast.
+func (s *Server) End() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := chat{
+		Thread:  ThreadID,
+		UserID:  UserID,
+		Premium: 3,
+		Date:    time.Now().Unix(),
+		Comment: "/disconnect",
+	}
+	return s.post(c)
+}
+
+// ExpirePostKey rejects the next comment posted to the server with
+// status 4, as if its post key had expired meanwhile.
+func (s *Server) ExpirePostKey() {
+	s.mu.Lock()
+	s.expireKey = true
+	s.mu.Unlock()
+}
+
+// DropConnections closes every message server connection,
+// as if the network went away.
+func (s *Server) DropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn := range s.clients {
+		conn.Close()
+		delete(s.clients, conn)
+	}
+}
+
+func (s *Server) loggedIn(r *http.Request) bool {
+	c, err := r.Cookie("user_session")
+	return err == nil && c.Value == Session
+}
+
+func (s *Server) login(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("mail") == Mail && r.FormValue("password") == Password {
+		http.SetCookie(w, &http.Cookie{Name: "user_session", Value: Session, Path: "/"})
+	}
+	fmt.Fprint(w, "ok")
+}
+
+func (s *Server) heartbeat(w http.ResponseWriter, r *http.Request) {
+	if !s.loggedIn(r) {
+		fmt.Fprint(w, `<nicolive_heartbeat status="fail"><error><code>NOTLOGIN</code></error></nicolive_heartbeat>`)
+		return
+	}
+	fmt.Fprint(w, `<nicolive_heartbeat status="ok"></nicolive_heartbeat>`)
+}
+
+func (s *Server) playerStatus(w http.ResponseWriter, r *http.Request) {
+	if !s.loggedIn(r) {
+		fmt.Fprint(w, `<getplayerstatus status="fail"><error><code>notlogin</code></error></getplayerstatus>`)
+		return
+	}
+
+	host, port, _ := net.SplitHostPort(s.ms.Addr().String())
+	fmt.Fprintf(w, `<getplayerstatus status="ok">`+
+		`<stream><title>fake broadcast</title><description></description><default_community>co1</default_community>`+
+		`<owner_id>2</owner_id><owner_name>owner</owner_name><start_time>%d</start_time><end_time>0</end_time></stream>`+
+		`<user><user_id>%s</user_id><nickname>me</nickname><is_premium>0</is_premium></user>`+
+		`<ms><addr>%s</addr><port>%s</port><thread>%d</thread></ms>`+
+		`</getplayerstatus>`,
+		s.StartTime, UserID, host, port, ThreadID)
+}
+
+func (s *Server) postKey(w http.ResponseWriter, r *http.Request) {
+	if !s.loggedIn(r) {
+		fmt.Fprint(w, "postkey=")
+		return
+	}
+	fmt.Fprint(w, "postkey="+PostKey)
+}
+
+func (s *Server) userInfo(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(r.FormValue("user_id"), 10, 64)
+
+	s.mu.Lock()
+	name, ok := s.users[id]
+	s.mu.Unlock()
+
+	if !ok {
+		fmt.Fprint(w, `<nicovideo_user_response status="fail"></nicovideo_user_response>`)
+		return
+	}
+	fmt.Fprintf(w, `<nicovideo_user_response status="ok"><user><id>%d</id><nickname>%s</nickname></user></nicovideo_user_response>`,
+		id, html.EscapeString(name))
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.ms.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go s.serve(conn)
+	}
+}
+
+func (s *Server) serve(conn net.Conn) {
+	defer s.wg.Done()
+	defer conn.Close()
+
+	var acc []byte
+	buf := make([]byte, 2048)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			s.mu.Lock()
+			delete(s.clients, conn)
+			s.mu.Unlock()
+			return
+		}
+
+		acc = append(acc, buf[0:n]...)
+		for {
+			p := bytes.IndexByte(acc, 0)
+			if p < 0 {
+				break
+			}
+			msg := acc[0:p]
+			acc = acc[p+1:]
+			s.handle(conn, msg)
+		}
+	}
+}
+
+func (s *Server) handle(conn net.Conn, msg []byte) {
+	switch {
+	case bytes.HasPrefix(msg, []byte("<thread ")):
+		var req threadReq
+		if err := xml.Unmarshal(msg, &req); err != nil {
+			return
+		}
+		s.open(conn, req)
+	case bytes.HasPrefix(msg, []byte("<chat ")):
+		var c chat
+		if err := xml.Unmarshal(msg, &c); err != nil {
+			return
+		}
+		s.receive(conn, c)
+	}
+}
+
+func (s *Server) open(conn net.Conn, req threadReq) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<thread resultcode="0" thread="%d" last_res="%d" ticket="%s" server_time="%d"/>`,
+		ThreadID, len(s.chats), Ticket, time.Now().Unix())
+	b.WriteByte(0)
+
+	from := 0
+	if req.ResFrom < 0 {
+		from = len(s.chats) + req.ResFrom
+	} else if req.ResFrom > 0 {
+		from = req.ResFrom - 1
+	}
+	if from < 0 {
+		from = 0
+	}
+	for i := from; i < len(s.chats); i++ {
+		writeChat(&b, s.chats[i])
+	}
+
+	if _, err := conn.Write(b.Bytes()); err != nil {
+		return
+	}
+	s.clients[conn] = true
+}
+
+func (s *Server) receive(conn net.Conn, c chat) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := 0
+	switch {
+	case c.Ticket != Ticket:
+		status = 3
+	case c.PostKey != PostKey || s.expireKey:
+		s.expireKey = false
+		status = 4
+	case strings.TrimSpace(c.Comment) == "":
+		status = 1
+	}
+	if status != 0 {
+		fmt.Fprintf(conn, `<chat_result thread="%d" status="%d"/>%c`, ThreadID, status, 0)
+		return
+	}
+
+	c.Ticket = ""
+	c.PostKey = ""
+	c.Date = time.Now().Unix()
+	no := s.post(c)
+	fmt.Fprintf(conn, `<chat_result thread="%d" status="0" no="%d"/>%c`, ThreadID, no, 0)
+}
+
+// post must be called with mu held.
+func (s *Server) post(c chat) int {
+	c.No = len(s.chats) + 1
+	s.chats = append(s.chats, c)
+
+	var b bytes.Buffer
+	writeChat(&b, c)
+	for conn := range s.clients {
+		conn.Write(b.Bytes())
+	}
+	return c.No
+}
+
+func writeChat(b *bytes.Buffer, c chat) {
+	// Comment is already escaped and goes out as innerxml
+	out, _ := xml.Marshal(c)
+	b.Write(out)
+	b.WriteByte(0)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+)
+
+// filter limits the comments shown in View. A nil filter shows everything.
+type filter struct {
+	desc string
+	fn   func(Chat) bool
+}
+
+func (f *filter) match(kome Chat) bool {
+	return f == nil || f.fn(kome)
+}
+
+// parseFilter understands "184", "raw", "user [ID]" and regexes over the comment.
+// Without an ID, "user" picks the user of the selected row.
+func (v *View) parseFilter(arg string) (*filter, error) {
+	switch {
+	case arg == "":
+		return nil, errors.New("usage: :filter 184|raw|user [ID]|REGEX")
+	case arg == "184":
+		return &filter{"184", func(kome Chat) bool { return !kome.User.IsRawUser }}, nil
+	case arg == "raw":
+		return &filter{"raw", func(kome Chat) bool { return kome.User.IsRawUser }}, nil
+	case arg == "user" || len(arg) > 5 && arg[0:5] == "user ":
+		id := ""
+		if arg == "user" {
+			if len(v.shown) == 0 {
+				return nil, errors.New("no comment selected")
+			}
+			id = v.komes[v.shown[v.ptr]].UserID
+		} else {
+			id = arg[5:]
+		}
+		return &filter{"user " + id, func(kome Chat) bool { return kome.UserID == id }}, nil
+	}
+
+	re, err := regexp.Compile(arg)
+	if err != nil {
+		return nil, err
+	}
+	return &filter{arg, func(kome Chat) bool { return re.MatchString(commentText(kome)) }}, nil
+}
+
+// commentText is the text shown for kome.
+func commentText(kome Chat) string {
+	if kome.Control != nil {
+		return kome.Control.Text
+	}
+	return kome.Comment
+}
+
+// accept reports whether kome is listed, counting the ones muted by NG.
+func (v *View) accept(kome Chat) bool {
+	if !v.filter.match(kome) {
+		return false
+	}
+	if v.ng.Match(kome) {
+		v.hidden++
+		return v.ngMask
+	}
+	return true
+}
+
+func (v *View) refilter() {
+	v.shown = v.shown[:0]
+	v.hidden = 0
+	for i, kome := range v.komes {
+		if v.accept(kome) {
+			v.shown = append(v.shown, i)
+		}
+	}
+}
+
+// setFilter replaces the filter, keeping the selection on the
+// same comment or the next one still shown.
+func (v *View) setFilter(f *filter) {
+	cur := 0
+	if len(v.shown) > 0 {
+		cur = v.shown[v.ptr]
+	}
+
+	v.filter = f
+	v.refilter()
+
+	v.top = 0
+	v.topRow = 0
+	v.ptr = sort.SearchInts(v.shown, cur)
+	v.fixPtr()
+}
+
+func (v *View) startSearch(pattern string, back bool) {
+	if pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			v.notify(err.Error(), true)
+			return
+		}
+		v.search = re
+	}
+	v.back = back
+	v.searchNext(back)
+}
+
+// searchNext moves ptr to the next match, wrapping around like vim.
+func (v *View) searchNext(back bool) {
+	if v.search == nil {
+		v.notify("no previous search", true)
+		return
+	}
+
+	n := len(v.shown)
+	step := 1
+	if back {
+		step = n - 1
+	}
+	for k := 1; k <= n; k++ {
+		i := (v.ptr + k*step) % n
+		if v.search.MatchString(commentText(v.komes[v.shown[i]])) {
+			switch {
+			case !back && i <= v.ptr:
+				v.notify("search hit BOTTOM, continuing at TOP", false)
+			case back && i >= v.ptr:
+				v.notify("search hit TOP, continuing at BOTTOM", false)
+			}
+			v.ptr = i
+			v.fixPtr()
+			return
+		}
+	}
+	v.notify("pattern not found: "+v.search.String(), true)
+}
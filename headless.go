@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+type jsonLine struct {
+	Type    string `json:"type"`
+	Chat    *Chat  `json:"chat,omitempty"`
+	History bool   `json:"history,omitempty"` // logged by an earlier run
+	UserID  string `json:"user_id,omitempty"`
+	User    *User  `json:"user,omitempty"`
+}
+
+// Headless writes everything Live receives to w as JSON Lines
+// instead of drawing it with termbox.
+type Headless struct {
+	live    *Live
+	log     *CommentLog
+	enc     *json.Encoder
+	overlay *Overlay
+	hooks   *Hooks
+
+	// comments waiting for their user
+	held userWaitQueue
+}
+
+func NewHeadless(live *Live, log *CommentLog, w io.Writer) *Headless {
+	return &Headless{
+		live: live,
+		log:  log,
+		enc:  json.NewEncoder(w),
+	}
+}
+
+// WriteHistory writes the comments logged by earlier runs, which
+// Live does not receive again, as chat lines marked as history.
+func (h *Headless) WriteHistory(history []Chat) error {
+	for i := range history {
+		if err := h.enc.Encode(jsonLine{Type: "chat", Chat: &history[i], History: true}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Loop runs until an interrupt arrives, the broadcast ends or it can no
+// longer be reached.
+func (h *Headless) Loop() error {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+
+	tick := time.NewTicker(userWait / 4)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-sigCh:
+			return h.release(true)
+		case <-h.live.Done:
+			return h.flush()
+		case <-tick.C:
+			if err := h.release(false); err != nil {
+				return err
+			}
+		case kome := <-h.live.KomeCh:
+			if err := h.receive(kome); err != nil {
+				return err
+			}
+		case err := <-h.log.ErrCh:
+			stdErr(err)
+		case up := <-h.live.repo.UpdateCh:
+			h.overlay.PushUser(up)
+			h.held.rename(up)
+			if err := h.release(false); err != nil {
+				return err
+			}
+			if err := h.enc.Encode(jsonLine{Type: "user", UserID: up.UserID, User: &up.User}); err != nil {
+				return err
+			}
+		case ev := <-h.live.EventCh:
+			if err := h.enc.Encode(ev); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (h *Headless) receive(kome Chat) error {
+	h.log.Save(h.live.LiveID, kome)
+	h.held.push(h.live.LiveID, kome)
+	return h.release(false)
+}
+
+// release writes the held comments that are ready, or all of them if all is set.
+func (h *Headless) release(all bool) error {
+	for {
+		k, ok := h.held.pop(all)
+		if !ok {
+			return nil
+		}
+
+		h.overlay.Push(k.liveID, k.kome)
+		h.hooks.Push(k.liveID, k.kome)
+		if err := h.enc.Encode(jsonLine{Type: "chat", Chat: &k.kome}); err != nil {
+			return err
+		}
+	}
+}
+
+// flush writes the comments and events still queued at the end.
+func (h *Headless) flush() error {
+drain:
+	for {
+		select {
+		case kome := <-h.live.KomeCh:
+			h.log.Save(h.live.LiveID, kome)
+			h.held.push(h.live.LiveID, kome)
+		default:
+			break drain
+		}
+	}
+	if err := h.release(true); err != nil {
+		return err
+	}
+	for {
+		select {
+		case ev := <-h.live.EventCh:
+			if err := h.enc.Encode(ev); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/kroton/kome/fakenico"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHeadlessReturnsWhenBroadcastEnds(t *testing.T) {
+	s, err := fakenico.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	lv, log := connectTestLive(t, s)
+	var out bytes.Buffer
+	done := make(chan error, 1)
+	go func() {
+		done <- NewHeadless(lv, log, &out).Loop()
+	}()
+
+	s.Post("10", "last words")
+	s.End()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Loop did not return after /disconnect")
+	}
+	if lv.State() != StateEnded {
+		t.Errorf("state = %v, want %v", lv.State(), StateEnded)
+	}
+	if !strings.Contains(out.String(), "last words") {
+		t.Errorf("comment before the end is missing from %q", out.String())
+	}
+}
+
+func TestHeadlessWritesHistory(t *testing.T) {
+	var out bytes.Buffer
+	h := NewHeadless(nil, nil, &out)
+	if err := h.WriteHistory([]Chat{{No: 1, Comment: "logged"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var line jsonLine
+	if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+		t.Fatal(err)
+	}
+	if line.Type != "chat" || !line.History || line.Chat == nil || line.Chat.Comment != "logged" {
+		t.Errorf("history line = %s", out.String())
+	}
+}
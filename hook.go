@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// hookQueue is how many comments may wait for the hooks before new ones
+// are dropped.
+const hookQueue = 256
+
+// HookConfig is a command run for every comment, e.g. a text-to-speech
+// engine. The command gets the comment on stdin and in KOME_* variables.
+type HookConfig struct {
+	Command      string `json:"command"`
+	Skip184      bool   `json:"skip_184"`
+	SkipOperator bool   `json:"skip_operator"`
+	MaxLength    int    `json:"max_length"`
+}
+
+func (h HookConfig) accept(kome Chat) bool {
+	if h.Skip184 && !kome.User.IsRawUser {
+		return false
+	}
+	if h.SkipOperator && kome.IsOperator() {
+		return false
+	}
+	if h.MaxLength > 0 && len([]rune(kome.Comment)) > h.MaxLength {
+		return false
+	}
+	return true
+}
+
+type hookJob struct {
+	liveID string
+	kome   Chat
+}
+
+// Hooks runs the configured commands one at a time in the background,
+// in the order the comments arrived. Muted comments are skipped.
+type Hooks struct {
+	hooks []HookConfig
+	ng    *NGList
+	jobs  chan hookJob
+
+	mu      sync.Mutex
+	enabled bool
+	running *exec.Cmd
+}
+
+// NewHooks returns nil if there are no hooks.
+func NewHooks(hooks []HookConfig, ng *NGList) *Hooks {
+	if len(hooks) == 0 {
+		return nil
+	}
+	h := &Hooks{
+		hooks:   hooks,
+		ng:      ng,
+		jobs:    make(chan hookJob, hookQueue),
+		enabled: true,
+	}
+	go h.work()
+	return h
+}
+
+// Push queues kome without blocking.
+func (h *Hooks) Push(liveID string, kome Chat) {
+	if h == nil || !h.Enabled() || h.ng.Match(kome) {
+		return
+	}
+	select {
+	case h.jobs <- hookJob{liveID, kome}:
+	default:
+		// the hooks can't keep up, skip this comment
+	}
+}
+
+func (h *Hooks) Enabled() bool {
+	if h == nil {
+		return false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.enabled
+}
+
+// Toggle switches the hooks off or back on. Switching off kills the
+// running command and throws away the queued comments.
+func (h *Hooks) Toggle() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.enabled = !h.enabled
+	if h.enabled {
+		return true
+	}
+	if h.running != nil && h.running.Process != nil {
+		h.running.Process.Kill()
+	}
+	for {
+		select {
+		case <-h.jobs:
+		default:
+			return false
+		}
+	}
+}
+
+func (h *Hooks) work() {
+	for job := range h.jobs {
+		for _, hook := range h.hooks {
+			if hook.accept(job.kome) {
+				h.run(hook, job)
+			}
+		}
+	}
+}
+
+func (h *Hooks) run(hook HookConfig, job hookJob) {
+	args := splitArgs(hook.Command)
+	if len(args) == 0 {
+		return
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = strings.NewReader(job.kome.Comment)
+	cmd.Env = append(os.Environ(),
+		"KOME_LIVE_ID="+job.liveID,
+		"KOME_NO="+strconv.Itoa(job.kome.No),
+		"KOME_USER_ID="+job.kome.UserID,
+		"KOME_USER_NAME="+job.kome.User.Name,
+		"KOME_COMMENT="+job.kome.Comment,
+	)
+
+	h.mu.Lock()
+	if !h.enabled {
+		h.mu.Unlock()
+		return
+	}
+	if err := cmd.Start(); err != nil {
+		h.mu.Unlock()
+		return
+	}
+	h.running = cmd
+	h.mu.Unlock()
+
+	cmd.Wait()
+
+	h.mu.Lock()
+	h.running = nil
+	h.mu.Unlock()
+}
+
+// validateHooks reports the first hook without a command.
+func validateHooks(hooks []HookConfig) error {
+	for i, hook := range hooks {
+		if len(splitArgs(hook.Command)) == 0 {
+			return fmt.Errorf("empty command for hook %d", i+1)
+		}
+	}
+	return nil
+}
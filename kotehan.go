@@ -0,0 +1,67 @@
+package main
+
+import (
+	"regexp"
+)
+
+// kotehanReg finds "@name" at the start of a comment or after a space,
+// so mail addresses are not taken for names.
+var kotehanReg = regexp.MustCompile(`(?:^|\s)[@＠]([^\s@＠]{1,32})`)
+
+// findKotehan returns the last @name in comment, or "".
+func findKotehan(comment string) string {
+	m := kotehanReg.FindAllStringSubmatch(comment, -1)
+	if len(m) == 0 {
+		return ""
+	}
+	return m[len(m)-1][1]
+}
+
+func (r *UserRepo) LoadKotehan() error {
+	rows, err := r.db.Query("select user_id, name from kotehan")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for rows.Next() {
+		var id, name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return err
+		}
+		r.kotehan[id] = name
+	}
+	return rows.Err()
+}
+
+// SetKotehan names ID, 184 IDs included, until it is set again.
+// An empty name goes back to the name from user.info.
+func (r *UserRepo) SetKotehan(ID, name string) error {
+	r.mu.Lock()
+	if r.kotehan[ID] == name {
+		r.mu.Unlock()
+		return nil
+	}
+	if name == "" {
+		delete(r.kotehan, ID)
+	} else {
+		r.kotehan[ID] = name
+	}
+	r.mu.Unlock()
+
+	r.notify(ID)
+
+	if name == "" {
+		_, err := r.db.Exec("delete from kotehan where user_id = ?", ID)
+		return err
+	}
+	_, err := r.db.Exec(
+		`insert into kotehan(user_id, name) values(?, ?)
+		on conflict(user_id) do update set name = excluded.name`,
+		ID, name,
+	)
+	return err
+}
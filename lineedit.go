@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"github.com/nsf/termbox-go"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const historyMax = 1000
+
+// History keeps sent comments and commands, one per line with the mode
+// rune first, the same way View.execCommand sees them.
+type History struct {
+	path    string
+	entries []string
+}
+
+// LoadHistory starts empty if path does not exist.
+func LoadHistory(path string) (*History, error) {
+	h := &History{path: path}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if line := sc.Text(); line != "" {
+			h.entries = append(h.entries, line)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(h.entries) > historyMax {
+		h.entries = h.entries[len(h.entries)-historyMax:]
+		data := strings.Join(h.entries, "\n") + "\n"
+		if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+func (h *History) Add(line string) error {
+	if strings.ContainsAny(line, "\r\n") {
+		return nil
+	}
+	if n := len(h.entries); n > 0 && h.entries[n-1] == line {
+		return nil
+	}
+	h.entries = append(h.entries, line)
+
+	f, err := os.OpenFile(h.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(line + "\n")
+	return err
+}
+
+// lineEditor is the input of the command line. mode is 'i', ':', '/' or '?',
+// and 0 while nothing is being typed.
+type lineEditor struct {
+	mode rune
+	buf  []rune
+	pos  int
+
+	hist    *History
+	histPos int
+	saved   []rune
+
+	// Tab completion in progress
+	cands    []string
+	candIdx  int
+	candFrom int
+}
+
+func (e *lineEditor) active() bool {
+	return e.mode != 0
+}
+
+func (e *lineEditor) start(mode rune) {
+	e.mode = mode
+	e.buf = nil
+	e.pos = 0
+	e.cands = nil
+	e.histPos = len(e.hist.entries)
+	e.saved = nil
+}
+
+func (e *lineEditor) reset() {
+	e.mode = 0
+	e.buf = nil
+	e.pos = 0
+	e.cands = nil
+}
+
+// String returns the line with its mode rune first.
+func (e *lineEditor) String() string {
+	return string(e.mode) + string(e.buf)
+}
+
+func (e *lineEditor) insert(c rune) {
+	e.buf = append(e.buf, 0)
+	copy(e.buf[e.pos+1:], e.buf[e.pos:])
+	e.buf[e.pos] = c
+	e.pos++
+}
+
+func (e *lineEditor) delete(from, to int) {
+	e.buf = append(e.buf[:from], e.buf[to:]...)
+	e.pos = from
+}
+
+// handle applies an editing key. Backspace on an empty line leaves
+// every mode but 'i', like vim does for ':'.
+func (e *lineEditor) handle(ev termbox.Event) {
+	// any key but Tab accepts the completion
+	e.cands = nil
+
+	switch ev.Key {
+	case termbox.KeyArrowLeft, termbox.KeyCtrlB:
+		if e.pos > 0 {
+			e.pos--
+		}
+	case termbox.KeyArrowRight, termbox.KeyCtrlF:
+		if e.pos < len(e.buf) {
+			e.pos++
+		}
+	case termbox.KeyHome, termbox.KeyCtrlA:
+		e.pos = 0
+	case termbox.KeyEnd, termbox.KeyCtrlE:
+		e.pos = len(e.buf)
+	case termbox.KeyBackspace, termbox.KeyBackspace2:
+		if e.pos > 0 {
+			e.delete(e.pos-1, e.pos)
+		} else if len(e.buf) == 0 && e.mode != 'i' {
+			e.reset()
+		}
+	case termbox.KeyDelete, termbox.KeyCtrlD:
+		if e.pos < len(e.buf) {
+			e.delete(e.pos, e.pos+1)
+		}
+	case termbox.KeyCtrlW:
+		p := e.pos
+		for p > 0 && e.buf[p-1] == ' ' {
+			p--
+		}
+		for p > 0 && e.buf[p-1] != ' ' {
+			p--
+		}
+		e.delete(p, e.pos)
+	case termbox.KeyCtrlU:
+		e.delete(0, e.pos)
+	case termbox.KeyCtrlK:
+		e.buf = e.buf[:e.pos]
+	case termbox.KeyArrowUp, termbox.KeyCtrlP:
+		e.browse(-1)
+	case termbox.KeyArrowDown, termbox.KeyCtrlN:
+		e.browse(1)
+	case termbox.KeySpace:
+		e.insert(' ')
+	default:
+		if ev.Ch != 0 {
+			e.insert(ev.Ch)
+		}
+	}
+}
+
+// browse moves through history entries of the current mode.
+// Going past the newest one brings back what was being typed.
+func (e *lineEditor) browse(dir int) {
+	if e.histPos == len(e.hist.entries) {
+		e.saved = append([]rune(nil), e.buf...)
+	}
+
+	mode := string(e.mode)
+	for p := e.histPos + dir; p >= 0 && p <= len(e.hist.entries); p += dir {
+		if p == len(e.hist.entries) {
+			e.histPos = p
+			e.buf = e.saved
+			e.pos = len(e.buf)
+			return
+		}
+		if strings.HasPrefix(e.hist.entries[p], mode) {
+			e.histPos = p
+			e.buf = []rune(e.hist.entries[p][len(mode):])
+			e.pos = len(e.buf)
+			return
+		}
+	}
+}
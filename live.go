@@ -22,9 +22,9 @@ var (
 )
 
 type User struct {
-	ID        int64  `xml:"id"`
-	Name      string `xml:"nickname"`
-	IsRawUser bool   `xml:"-"`
+	ID        int64  `xml:"id" json:"id"`
+	Name      string `xml:"nickname" json:"name"`
+	IsRawUser bool   `xml:"-" json:"is_raw_user"`
 }
 
 type PlayerStatus struct {
@@ -54,32 +54,86 @@ type PlayerStatus struct {
 }
 
 type Thread struct {
-	ResultCode int    `xml:"resultcode,attr"`
-	LastRes    int    `xml:"last_res,attr"`
-	Ticket     string `xml:"ticket,attr"`
-	ServerTime int64  `xml:"server_time,attr"`
+	ResultCode int    `xml:"resultcode,attr" json:"resultcode"`
+	LastRes    int    `xml:"last_res,attr" json:"last_res"`
+	Ticket     string `xml:"ticket,attr" json:"-"`
+	ServerTime int64  `xml:"server_time,attr" json:"server_time"`
 }
 
 type Chat struct {
-	XMLName xml.Name `xml:"chat"`
-	Thread  int64    `xml:"thread,attr"`
-	No      int      `xml:"no,attr"`
-	Vpos    int64    `xml:"vpos,attr"`
-	Date    int64    `xml:"date,attr"`
-	UserID  string   `xml:"user_id,attr"`
-	Premium int      `xml:"premium,attr"`
-	Mail    string   `xml:"mail,attr"`
-	Ticket  string   `xml:"ticket,attr"`
-	PostKey string   `xml:"postkey,attr"`
-	Comment string   `xml:",innerxml"`
-	User    User     `xml:"-"`
+	XMLName xml.Name `xml:"chat" json:"-"`
+	Thread  int64    `xml:"thread,attr" json:"thread"`
+	No      int      `xml:"no,attr" json:"no"`
+	Vpos    int64    `xml:"vpos,attr" json:"vpos"`
+	Date    int64    `xml:"date,attr" json:"date"`
+	UserID  string   `xml:"user_id,attr" json:"user_id"`
+	Premium int      `xml:"premium,attr" json:"premium"`
+	Mail    string   `xml:"mail,attr" json:"mail"`
+	Ticket  string   `xml:"ticket,attr" json:"-"`
+	PostKey string   `xml:"postkey,attr" json:"-"`
+	Comment string   `xml:",innerxml" json:"comment"`
+	User    User     `xml:"-" json:"user"`
+	Control *Control `xml:"-" json:"control,omitempty"`
 }
 
 type ChatResult struct {
-	Status int `xml:"status"`
-	No     int `xml:"no"`
+	Status int `xml:"status,attr" json:"status"`
+	No     int `xml:"no,attr" json:"no"`
 }
 
+const (
+	EventThread     = "thread"
+	EventChatResult = "chat_result"
+	EventDisconnect = "disconnect"
+	EventGaveUp     = "gave_up"
+	EventEnded      = "ended"
+	EventSend       = "send"
+	EventControl    = "control"
+)
+
+// Event reports what happens on the message server connection
+// other than comments, which are sent on KomeCh. EventSend is not sent
+// on EventCh; it wraps what SendResults returns for the view.
+type Event struct {
+	Type    string      `json:"type"`
+	Thread  *Thread     `json:"thread,omitempty"`
+	Result  *ChatResult `json:"chat_result,omitempty"`
+	Send    *SendResult `json:"send,omitempty"`
+	Control *Control    `json:"control,omitempty"`
+}
+
+type ConnState int
+
+const (
+	StateConnected ConnState = iota
+	StateReconnecting
+	StateGaveUp
+	StateEnded
+)
+
+// errEnded is returned by a dialer that has nothing more to connect to.
+var errEnded = errors.New("ended")
+
+func (s ConnState) String() string {
+	switch s {
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateGaveUp:
+		return "gave up"
+	case StateEnded:
+		return "ended"
+	}
+	return "unknown"
+}
+
+const (
+	reconnectTries   = 8
+	reconnectMinWait = time.Second
+	reconnectMaxWait = time.Minute
+)
+
 type Live struct {
 	account *Account
 	repo    *UserRepo
@@ -87,36 +141,66 @@ type Live struct {
 	LiveID string
 	Status PlayerStatus
 
-	socket   *net.TCPConn
+	dial     func() (net.Conn, error)
+	recorder *Recorder
+	socket   net.Conn
 	buf      []byte
 	acc      []byte
 	thread   Thread
 	openTime int64
-
-	KomeCh chan Chat
-	sig    chan struct{}
-	wg     sync.WaitGroup
-
-	mu     sync.Mutex
-	lastNo int
+	timeout  time.Duration
+
+	KomeCh  chan Chat
+	EventCh chan Event
+	SendCh  chan struct{} // signalled when SendResults has something
+	Done    chan struct{} // closed when the broadcast ended or reconnecting gave up
+	sig     chan struct{}
+	wg      sync.WaitGroup
+
+	mu         sync.Mutex
+	lastNo     int
+	lastChatNo int
+	state      ConnState
+	pending    []*pendingSend
+
+	// comments waiting for sendLoop
+	outbox       []*pendingSend
+	nextSendID   int
+	sendInterval time.Duration
+	sendCh       chan struct{}
+	results      []*SendResult
 
 	writeMu sync.Mutex
+	closed  bool
 }
 
 func NewLive(account *Account, repo *UserRepo, liveID string) *Live {
-	return &Live{
+	lv := &Live{
 		account: account,
 		repo:    repo,
 		LiveID:  liveID,
 		buf:     make([]byte, 2048),
 		acc:     make([]byte, 0, 2048),
 		KomeCh:  make(chan Chat, 1024),
+		EventCh: make(chan Event, 64),
+		SendCh:  make(chan struct{}, 1),
+		Done:    make(chan struct{}),
 		sig:     make(chan struct{}),
+
+		sendInterval: defaultSendInterval,
+		sendCh:       make(chan struct{}, 1),
 	}
+	lv.dial = lv.dialTCP
+	return lv
+}
+
+// Record saves everything read from the message server to rec.
+func (lv *Live) Record(rec *Recorder) {
+	lv.recorder = rec
 }
 
 func (lv *Live) LoadPlayerStatus() error {
-	u := fmt.Sprintf("http://watch.live.nicovideo.jp/api/getplayerstatus?v=%s", lv.LiveID)
+	u := fmt.Sprintf("%s/api/getplayerstatus?v=%s", lv.account.Endpoints.Watch, lv.LiveID)
 	client := lv.account.NewClient()
 	res, err := client.Get(u)
 	if err != nil {
@@ -149,27 +233,74 @@ func (lv *Live) write(b []byte) error {
 	return nil
 }
 
+// Resume marks comments up to no as already delivered, so Connect
+// only asks for the ones after it.
+func (lv *Live) Resume(no int) {
+	lv.mu.Lock()
+	lv.lastNo = no
+	lv.lastChatNo = no
+	lv.mu.Unlock()
+}
+
 func (lv *Live) Connect(timeout time.Duration) error {
+	lv.timeout = timeout
+	if err := lv.open(lv.resFrom()); err != nil {
+		return err
+	}
+
+	lv.wg.Add(2)
+	go lv.process()
+	go lv.keepAlive()
+	// not waited for by Close, getpostkey may take long
+	go lv.sendLoop()
+	return nil
+}
+
+func (lv *Live) dialTCP() (net.Conn, error) {
 	addr := fmt.Sprintf("%s:%d", lv.Status.Ms.Addr, lv.Status.Ms.Port)
 	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	return net.DialTCP("tcp", nil, tcpAddr)
+}
+
+func (lv *Live) read(socket net.Conn) (int, error) {
+	n, err := socket.Read(lv.buf)
+	if n > 0 && lv.recorder != nil {
+		lv.recorder.Record(lv.buf[0:n])
 	}
-	lv.socket, err = net.DialTCP("tcp", nil, tcpAddr)
+	return n, err
+}
+
+// open dials the message server and waits for the thread response.
+// resFrom is sent as is, so a positive value requests comments from that number.
+func (lv *Live) open(resFrom int) error {
+	socket, err := lv.dial()
 	if err != nil {
 		return err
 	}
 
-	t := fmt.Sprintf(`<thread thread="%d" version="20061206" res_from="-1000"/>`, lv.Status.Ms.Thread)
+	lv.writeMu.Lock()
+	if lv.closed {
+		lv.writeMu.Unlock()
+		socket.Close()
+		return errors.New("closed")
+	}
+	lv.socket = socket
+	lv.writeMu.Unlock()
+
+	t := fmt.Sprintf(`<thread thread="%d" version="20061206" res_from="%d"/>`, lv.Status.Ms.Thread, resFrom)
 	if err := lv.write([]byte(t)); err != nil {
-		lv.socket.Close()
+		socket.Close()
 		return err
 	}
 
+	lv.acc = lv.acc[:0]
 	ch := make(chan error, 1)
 	go func() {
 		for {
-			n, err := lv.socket.Read(lv.buf)
+			n, err := lv.read(socket)
 			if err != nil {
 				ch <- err
 				return
@@ -183,13 +314,23 @@ func (lv *Live) Connect(timeout time.Duration) error {
 				}
 
 				end := p + len(tagThreadEnd)
-				if err := xml.Unmarshal(lv.acc[0:end], &lv.thread); err != nil {
+				var thread Thread
+				if err := xml.Unmarshal(lv.acc[0:end], &thread); err != nil {
 					ch <- err
 					return
 				}
 
+				lv.mu.Lock()
+				lv.thread = thread
 				lv.openTime = time.Now().Unix()
-				lv.lastNo = lv.thread.LastRes
+				if lv.lastNo < thread.LastRes {
+					lv.lastNo = thread.LastRes
+				}
+				lv.state = StateConnected
+				lv.mu.Unlock()
+
+				lv.emit(Event{Type: EventThread, Thread: &thread})
+
 				lv.acc = lv.acc[end:]
 				ch <- nil
 				return
@@ -200,21 +341,88 @@ func (lv *Live) Connect(timeout time.Duration) error {
 	select {
 	case err := <-ch:
 		if err != nil {
-			lv.socket.Close()
+			socket.Close()
 			return err
 		}
-	case <-time.After(timeout):
-		lv.socket.Close()
+	case <-time.After(lv.timeout):
+		socket.Close()
 		<-ch
 		return errors.New("timeout")
 	}
-
-	lv.wg.Add(2)
-	go lv.process()
-	go lv.keepAlive()
 	return nil
 }
 
+func (lv *Live) resFrom() int {
+	lv.mu.Lock()
+	defer lv.mu.Unlock()
+
+	if lv.lastChatNo > 0 {
+		return lv.lastChatNo + 1
+	}
+	return -1000
+}
+
+// emit never blocks; events are dropped when nobody reads EventCh.
+func (lv *Live) emit(ev Event) {
+	select {
+	case lv.EventCh <- ev:
+	default:
+	}
+}
+
+func (lv *Live) State() ConnState {
+	lv.mu.Lock()
+	defer lv.mu.Unlock()
+	return lv.state
+}
+
+func (lv *Live) setState(state ConnState) {
+	lv.mu.Lock()
+	lv.state = state
+	lv.mu.Unlock()
+}
+
+// reconnect retries open with exponential backoff, asking the server
+// for every comment after the last one delivered so the gap is filled in.
+func (lv *Live) reconnect() bool {
+	lv.setState(StateReconnecting)
+	lv.failPending()
+
+	wait := reconnectMinWait
+	for i := 0; i < reconnectTries; i++ {
+		select {
+		case <-time.After(wait):
+		case <-lv.sig:
+			return false
+		}
+
+		err := lv.open(lv.resFrom())
+		if err == nil {
+			return true
+		}
+		if err == errEnded {
+			lv.finish(StateEnded, EventEnded)
+			return false
+		}
+
+		wait *= 2
+		if wait > reconnectMaxWait {
+			wait = reconnectMaxWait
+		}
+	}
+
+	lv.finish(StateGaveUp, EventGaveUp)
+	return false
+}
+
+// finish is called by process when no more comments will come.
+// Unlike the event, which is dropped if EventCh is full, Done can't be missed.
+func (lv *Live) finish(state ConnState, evType string) {
+	lv.setState(state)
+	lv.emit(Event{Type: evType})
+	close(lv.Done)
+}
+
 func (lv *Live) process() {
 	defer lv.wg.Done()
 
@@ -235,22 +443,45 @@ func (lv *Live) process() {
 					continue
 				}
 
+				// drop comments already delivered before a reconnect
+				lv.mu.Lock()
+				dup := kome.No <= lv.lastChatNo
+				if !dup {
+					lv.lastNo = kome.No
+					lv.lastChatNo = kome.No
+				}
+				lv.mu.Unlock()
+				if dup {
+					continue
+				}
+
 				// unescape comment
 				kome.Comment = html.UnescapeString(kome.Comment)
 
+				// operator commands; "@name" from anyone else is a kotehan
+				if kome.IsOperator() {
+					kome.Control = parseControl(kome.Comment)
+				} else if name := findKotehan(kome.Comment); name != "" {
+					lv.repo.SetKotehan(kome.UserID, name)
+				}
+
 				// load User data
 				kome.User = lv.repo.Get(kome.UserID)
 
-				lv.mu.Lock()
-				lv.lastNo = kome.No
-				lv.mu.Unlock()
-
 				select {
 				case lv.KomeCh <- kome:
 				case <-lv.sig:
 					return
 				}
 
+				if kome.Control != nil {
+					lv.emit(Event{Type: EventControl, Control: kome.Control})
+					if kome.Control.Command == ControlDisconnect {
+						lv.end()
+						return
+					}
+				}
+
 				continue
 			}
 
@@ -270,24 +501,56 @@ func (lv *Live) process() {
 				}
 
 				lv.mu.Lock()
-				lv.lastNo = res.No
+				if res.No > lv.lastNo {
+					lv.lastNo = res.No
+				}
 				lv.mu.Unlock()
 
+				lv.emit(Event{Type: EventChatResult, Result: &res})
+				lv.handleChatResult(res)
+
+				continue
+			}
+
+			// skip anything else up to its terminator,
+			// e.g. the thread tag of a reconnect in a recording
+			if p := bytes.IndexByte(lv.acc, 0); p >= 0 {
+				lv.acc = lv.acc[p+1:]
 				continue
 			}
 
 			break
 		}
 
-		n, err := lv.socket.Read(lv.buf)
+		n, err := lv.read(lv.socket)
 		if err != nil {
-			return
+			select {
+			case <-lv.sig:
+				return
+			default:
+			}
+
+			lv.emit(Event{Type: EventDisconnect})
+			if !lv.reconnect() {
+				return
+			}
+			continue
 		}
 
 		lv.acc = append(lv.acc, lv.buf[0:n]...)
 	}
 }
 
+// end is called when the broadcast is over.
+// The socket is closed and no reconnection is attempted.
+func (lv *Live) end() {
+	lv.finish(StateEnded, EventEnded)
+
+	lv.writeMu.Lock()
+	lv.socket.Close()
+	lv.writeMu.Unlock()
+}
+
 func (lv *Live) keepAlive() {
 	defer lv.wg.Done()
 
@@ -295,9 +558,8 @@ func (lv *Live) keepAlive() {
 	for {
 		select {
 		case <-tick:
-			if err := lv.write(nil); err != nil {
-				return
-			}
+			// a failed write is picked up by process, which reconnects
+			lv.write(nil)
 		case <-lv.sig:
 			return
 		}
@@ -305,8 +567,13 @@ func (lv *Live) keepAlive() {
 }
 
 func (lv *Live) Close() {
-	lv.socket.Close()
 	close(lv.sig)
+
+	lv.writeMu.Lock()
+	lv.closed = true
+	lv.socket.Close()
+	lv.writeMu.Unlock()
+
 	lv.wg.Wait()
 }
 
@@ -315,7 +582,7 @@ func (lv *Live) getPostKey() (string, error) {
 	blockNum := lv.lastNo / 10
 	lv.mu.Unlock()
 
-	u := fmt.Sprintf("http://live.nicovideo.jp/api/getpostkey?thread=%d&block_no=%d", lv.Status.Ms.Thread, blockNum)
+	u := fmt.Sprintf("%s/api/getpostkey?thread=%d&block_no=%d", lv.account.Endpoints.Live, lv.Status.Ms.Thread, blockNum)
 	client := lv.account.NewClient()
 	res, err := client.Get(u)
 	if err != nil {
@@ -335,38 +602,7 @@ func (lv *Live) getPostKey() (string, error) {
 }
 
 func (lv *Live) calcVpos() int64 {
+	lv.mu.Lock()
+	defer lv.mu.Unlock()
 	return 100 * (lv.thread.ServerTime - lv.Status.Stream.StartTime + time.Now().Unix() - lv.openTime)
 }
-
-func (lv *Live) SendKome(comment string, is184 bool) error {
-	postkey, err := lv.getPostKey()
-	if err != nil {
-		return err
-	}
-
-	vpos := lv.calcVpos()
-	mail := ""
-	if is184 {
-		mail = "184"
-	}
-
-	kome := Chat{
-		Thread:  lv.Status.Ms.Thread,
-		Ticket:  lv.thread.Ticket,
-		Vpos:    vpos,
-		PostKey: postkey,
-		UserID:  lv.Status.User.UserID,
-		Premium: lv.Status.User.IsPremium,
-		Mail:    mail,
-		Comment: html.EscapeString(comment),
-	}
-
-	b, err := xml.Marshal(kome)
-	if err != nil {
-		return err
-	}
-	if err := lv.write(b); err != nil {
-		return err
-	}
-	return nil
-}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"github.com/kroton/kome/fakenico"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// connectTestLive logs in to s and connects to a broadcast on it.
+func connectTestLive(t *testing.T, s *fakenico.Server) (*Live, *CommentLog) {
+	e := testEndpoints(s)
+	a := &Account{Mail: fakenico.Mail, Password: fakenico.Password, Endpoints: e}
+	if err := a.Login(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := OpenWithMigrate(filepath.Join(t.TempDir(), "user.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	log := NewCommentLog(db)
+	t.Cleanup(log.Close)
+
+	lv, _, err := loadLive(a, NewUserRepo(db, e), log, "lv1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lv.Connect(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(lv.Close)
+	return lv, log
+}
+
+// receiveKome waits for the next comment of lv.
+func receiveKome(t *testing.T, lv *Live) Chat {
+	t.Helper()
+	select {
+	case kome := <-lv.KomeCh:
+		return kome
+	case <-time.After(5 * time.Second):
+		t.Fatal("no comment received")
+	}
+	return Chat{}
+}
+
+func TestLoadPlayerStatus(t *testing.T) {
+	s, err := fakenico.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	a := &Account{Session: fakenico.Session, Endpoints: testEndpoints(s)}
+	lv := NewLive(a, nil, "lv1")
+	if err := lv.LoadPlayerStatus(); err != nil {
+		t.Fatal(err)
+	}
+	if lv.Status.User.UserID != fakenico.UserID {
+		t.Errorf("user_id = %q, want %q", lv.Status.User.UserID, fakenico.UserID)
+	}
+	if lv.Status.Ms.Thread != fakenico.ThreadID || lv.Status.Ms.Port == 0 {
+		t.Errorf("message server = %+v", lv.Status.Ms)
+	}
+
+	a.Session = "user_session_expired"
+	if err := NewLive(a, nil, "lv1").LoadPlayerStatus(); err == nil {
+		t.Error("getplayerstatus without login succeeded")
+	}
+}
+
+func TestLiveReceivesComments(t *testing.T) {
+	s, err := fakenico.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	s.Post("10", "before connecting")
+	lv, _ := connectTestLive(t, s)
+	s.Post("11", "<b>&amp;</b>")
+
+	want := []string{"before connecting", "<b>&amp;</b>"}
+	for i, w := range want {
+		kome := receiveKome(t, lv)
+		if kome.No != i+1 || kome.Comment != w {
+			t.Errorf("comment %d = No.%d %q, want No.%d %q", i, kome.No, kome.Comment, i+1, w)
+		}
+	}
+}
+
+func TestLiveReconnectFillsTheGap(t *testing.T) {
+	s, err := fakenico.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	lv, _ := connectTestLive(t, s)
+	s.Post("10", "1")
+	receiveKome(t, lv)
+
+	s.DropConnections()
+	// posted while disconnected, only res_from brings them back
+	s.Post("10", "2")
+	s.Post("10", "3")
+
+	for no := 2; no <= 3; no++ {
+		kome := receiveKome(t, lv)
+		if kome.No != no {
+			t.Fatalf("after reconnecting got No.%d, want No.%d", kome.No, no)
+		}
+	}
+	if lv.State() != StateConnected {
+		t.Errorf("state = %v, want %v", lv.State(), StateConnected)
+	}
+
+	s.Post("10", "4")
+	kome := receiveKome(t, lv)
+	if kome.No != 4 {
+		t.Errorf("got No.%d, want No.4", kome.No)
+	}
+	select {
+	case kome := <-lv.KomeCh:
+		t.Errorf("unexpected No.%d %q", kome.No, kome.Comment)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// sendStates collects the states of the comment id until it is sent or fails.
+func sendStates(t *testing.T, lv *Live, id int) ([]string, *SendResult) {
+	t.Helper()
+	var states []string
+	for {
+		select {
+		case <-lv.SendCh:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no chat_result, states so far %v", states)
+		}
+		for _, r := range lv.SendResults() {
+			if r.ID != id {
+				t.Fatalf("result for #%d, want #%d", r.ID, id)
+			}
+			states = append(states, r.State)
+			if r.State == SendSent || r.State == SendFailed {
+				return states, r
+			}
+		}
+	}
+}
+
+func TestSendKome(t *testing.T) {
+	s, err := fakenico.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	lv, _ := connectTestLive(t, s)
+	lv.SetSendInterval(0)
+	id, err := lv.SendKome("hello", MailCommand{Color: "red"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	states, sent := sendStates(t, lv, id)
+	want := []string{SendQueued, SendSending, SendSent}
+	if strings.Join(states, " ") != strings.Join(want, " ") {
+		t.Fatalf("states = %v (%v), want %v", states, sent.Error, want)
+	}
+	kome := receiveKome(t, lv)
+	if kome.No != sent.No || kome.Comment != "hello" || kome.Mail != "red" {
+		t.Errorf("posted No.%d %q mail %q, chat_result says No.%d", kome.No, kome.Comment, kome.Mail, sent.No)
+	}
+}
+
+func TestSendKomeRetriesExpiredPostKey(t *testing.T) {
+	s, err := fakenico.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	lv, _ := connectTestLive(t, s)
+	lv.SetSendInterval(0)
+	s.ExpirePostKey()
+	id, err := lv.SendKome("hello", MailCommand{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	states, sent := sendStates(t, lv, id)
+	want := []string{SendQueued, SendSending, SendQueued, SendSending, SendSent}
+	if strings.Join(states, " ") != strings.Join(want, " ") {
+		t.Fatalf("states = %v (%v), want %v", states, sent.Error, want)
+	}
+	if kome := receiveKome(t, lv); kome.No != sent.No {
+		t.Errorf("posted No.%d, chat_result says No.%d", kome.No, sent.No)
+	}
+}
+
+func TestSendKomeFailure(t *testing.T) {
+	s, err := fakenico.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	lv, _ := connectTestLive(t, s)
+	lv.SetSendInterval(0)
+	// fakenico rejects empty comments with status 1
+	id, err := lv.SendKome(" ", MailCommand{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, res := sendStates(t, lv, id)
+	if res.State != SendFailed || res.Error != chatResultMessages[1] {
+		t.Errorf("result = %+v, want failed with %q", *res, chatResultMessages[1])
+	}
+}
+
+func TestUserNameResolvedLater(t *testing.T) {
+	s, err := fakenico.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	s.AddUser(10, "alice")
+	lv, _ := connectTestLive(t, s)
+	s.Post("10", "hi")
+
+	kome := receiveKome(t, lv)
+	if !kome.User.IsRawUser || kome.User.Name != "10" {
+		t.Errorf("user before the lookup = %+v, want the raw ID as the name", kome.User)
+	}
+
+	select {
+	case up := <-lv.repo.UpdateCh:
+		if up.UserID != "10" || up.User.Name != "alice" || up.User.ID != 10 {
+			t.Errorf("update = %+v", up)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no UserUpdate")
+	}
+	if u := lv.repo.Get("10"); u.Name != "alice" {
+		t.Errorf("cached user = %+v", u)
+	}
+}
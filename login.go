@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"golang.org/x/term"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	profilesPath   = confPath + "/profiles"
+	profileNameReg = regexp.MustCompile(`^[0-9A-Za-z_-]+$`)
+)
+
+// profileAccountPath returns the account file of the named profile.
+// The unnamed profile is the original ~/.config/kome/account.json.
+func profileAccountPath(name string) (string, error) {
+	if name == "" {
+		return accountPath, nil
+	}
+	if !profileNameReg.MatchString(name) {
+		return "", fmt.Errorf("invalid profile name %v", name)
+	}
+	return profilesPath + "/" + name + ".json", nil
+}
+
+// loginAccount checks the session of the account and logs in again when
+// the server reports it has expired, asking for the passphrase of the saved
+// password. A plaintext password from an older account file is sealed first
+// if stdin is a terminal. Any change is saved to path.
+func loginAccount(a *Account, path string, profile string) error {
+	// without a terminal to ask for a passphrase, e.g. a --json bot,
+	// keep using the plaintext password until an interactive run
+	plaintext := a.IsPlaintext()
+	if plaintext && term.IsTerminal(int(os.Stdin.Fd())) {
+		if err := sealPlaintext(a, path); err != nil {
+			return err
+		}
+		if err := a.SaveTo(path); err != nil {
+			return err
+		}
+		plaintext = false
+	} else if plaintext {
+		fmt.Fprintf(os.Stderr, "warning: the password in %v is not encrypted, run kome interactively or kome login to encrypt it\n", path)
+	}
+
+	err := a.HeartBeat()
+	if err != errNotLogin {
+		return err
+	}
+	if a.Sealed == nil && !plaintext {
+		if profile == "" {
+			return errors.New("session expired, run kome login")
+		}
+		return fmt.Errorf("session expired, run kome login --profile %v", profile)
+	}
+
+	if a.Password == "" {
+		passphrase, err := promptPassword("passphrase")
+		if err != nil {
+			return err
+		}
+		if err := a.Unseal(passphrase); err != nil {
+			return err
+		}
+	}
+	if err := a.Login(); err != nil {
+		return err
+	}
+	if err := a.HeartBeat(); err != nil {
+		return err
+	}
+	if plaintext {
+		// saving now would drop the password, which isn't sealed yet
+		return nil
+	}
+	return a.SaveTo(path)
+}
+
+// sealPlaintext asks for a passphrase and seals the password of an account
+// file from an older version, which has it in plaintext.
+func sealPlaintext(a *Account, path string) error {
+	if !a.IsPlaintext() {
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "encrypting the password saved in %v\n", path)
+	passphrase, err := promptNewPassphrase()
+	if err != nil {
+		return err
+	}
+	return a.Seal(passphrase)
+}
+
+func loginMain(args []string) {
+	fs := flag.NewFlagSet("login", flag.ExitOnError)
+	profile := fs.String("profile", "", "name of the profile to log in to")
+	noSavePassword := fs.Bool("no-save-password", false, "save only the session, not the password")
+	fromBrowser := fs.String("from-browser", "", "take the session from this Firefox cookies.sqlite or Chromium Cookies file")
+	fs.Usage = usage
+	fs.Parse(args)
+
+	if fs.NArg() != 0 {
+		usage()
+		return
+	}
+
+	path, err := profileAccountPath(*profile)
+	if err != nil {
+		stdErr(err)
+		return
+	}
+
+	// keep the mail address of an existing profile as the default
+	account, err := LoadAccount(path)
+	if err != nil {
+		account = &Account{Endpoints: DefaultEndpoints}
+	}
+
+	if *fromBrowser != "" {
+		session, err := SessionFromBrowser(*fromBrowser, account.Endpoints)
+		if err != nil {
+			stdErr(err)
+			return
+		}
+		account.Session = session
+		if err := account.HeartBeat(); err != nil {
+			stdErr(err)
+			return
+		}
+		// the password of an older account file is kept, but sealed
+		if err := sealPlaintext(account, path); err != nil {
+			stdErr(err)
+			return
+		}
+		saveAccount(account, path)
+		return
+	}
+
+	mail, err := prompt("mail", account.Mail)
+	if err != nil {
+		stdErr(err)
+		return
+	}
+	password, err := promptPassword("password")
+	if err != nil {
+		stdErr(err)
+		return
+	}
+	account.Mail = mail
+	account.Password = password
+
+	if err := account.Login(); err != nil {
+		stdErr(err)
+		return
+	}
+	if err := account.HeartBeat(); err != nil {
+		stdErr(err)
+		return
+	}
+
+	if *noSavePassword {
+		account.Sealed = nil
+	} else {
+		passphrase, err := promptNewPassphrase()
+		if err != nil {
+			stdErr(err)
+			return
+		}
+		if err := account.Seal(passphrase); err != nil {
+			stdErr(err)
+			return
+		}
+	}
+	saveAccount(account, path)
+}
+
+func saveAccount(a *Account, path string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		stdErr(err)
+		return
+	}
+	if err := a.SaveTo(path); err != nil {
+		stdErr(err)
+		return
+	}
+	fmt.Fprintf(os.Stdout, "logged in, saved to %v\n", path)
+}
+
+var stdin = bufio.NewReader(os.Stdin)
+
+func prompt(name string, def string) (string, error) {
+	if def != "" {
+		fmt.Fprintf(os.Stderr, "%v [%v]: ", name, def)
+	} else {
+		fmt.Fprintf(os.Stderr, "%v: ", name)
+	}
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("failed to read %v", name)
+	}
+	if line = strings.TrimSpace(line); line == "" {
+		return def, nil
+	}
+	return line, nil
+}
+
+// promptPassword reads a password without echoing it when stdin is a terminal.
+func promptPassword(name string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return prompt(name, "")
+	}
+
+	fmt.Fprintf(os.Stderr, "%v: ", name)
+	b, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %v", name)
+	}
+	return string(b), nil
+}
+
+// promptNewPassphrase asks twice for the passphrase that seals the password.
+func promptNewPassphrase() (string, error) {
+	passphrase, err := promptPassword("new passphrase")
+	if err != nil {
+		return "", err
+	}
+	if passphrase == "" {
+		return "", errors.New("empty passphrase")
+	}
+	again, err := promptPassword("new passphrase again")
+	if err != nil {
+		return "", err
+	}
+	if passphrase != again {
+		return "", errors.New("passphrases do not match")
+	}
+	return passphrase, nil
+}
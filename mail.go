@@ -0,0 +1,166 @@
+package main
+
+import (
+	"github.com/nsf/termbox-go"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// mailColors maps color commands to the closest termbox color.
+// The second set is for premium members only.
+var mailColors = map[string]termbox.Attribute{
+	"white":  termbox.ColorWhite,
+	"red":    termbox.ColorRed,
+	"pink":   termbox.ColorMagenta,
+	"orange": termbox.ColorYellow,
+	"yellow": termbox.ColorYellow,
+	"green":  termbox.ColorGreen,
+	"cyan":   termbox.ColorCyan,
+	"blue":   termbox.ColorBlue,
+	"purple": termbox.ColorMagenta,
+	"black":  termbox.ColorBlack,
+
+	"white2":         termbox.ColorWhite,
+	"niconicowhite":  termbox.ColorWhite,
+	"red2":           termbox.ColorRed,
+	"truered":        termbox.ColorRed,
+	"pink2":          termbox.ColorMagenta,
+	"orange2":        termbox.ColorYellow,
+	"passionorange":  termbox.ColorYellow,
+	"yellow2":        termbox.ColorYellow,
+	"madyellow":      termbox.ColorYellow,
+	"green2":         termbox.ColorGreen,
+	"elementalgreen": termbox.ColorGreen,
+	"cyan2":          termbox.ColorCyan,
+	"blue2":          termbox.ColorBlue,
+	"marineblue":     termbox.ColorBlue,
+	"purple2":        termbox.ColorMagenta,
+	"nobleviolet":    termbox.ColorMagenta,
+	"black2":         termbox.ColorBlack,
+}
+
+var (
+	mailPositions = map[string]bool{"ue": true, "naka": true, "shita": true}
+	mailSizes     = map[string]bool{"big": true, "medium": true, "small": true}
+
+	mailColorCodeReg = regexp.MustCompile(`^#[0-9A-Fa-f]{6}$`)
+)
+
+// MailCommand is the mail attribute of a chat: how the comment is
+// shown on the player. Empty fields are the defaults.
+type MailCommand struct {
+	Color     string
+	Position  string
+	Size      string
+	Anonymous bool
+	Others    []string // commands kome does not know
+}
+
+// parseMail splits the space separated commands of a mail attribute.
+func parseMail(mail string) MailCommand {
+	var m MailCommand
+	for _, f := range strings.Fields(mail) {
+		if !m.set(f) {
+			m.Others = append(m.Others, f)
+		}
+	}
+	return m
+}
+
+// MailCommand parses the mail attribute of the chat.
+func (c Chat) MailCommand() MailCommand {
+	return parseMail(c.Mail)
+}
+
+// parseMailPrefix reads leading words of s as long as they are mail
+// commands and returns the commands and the rest of s.
+func parseMailPrefix(s string) (MailCommand, string) {
+	var m MailCommand
+	rest := strings.TrimLeft(s, " ")
+	for rest != "" {
+		word := rest
+		if i := strings.IndexByte(rest, ' '); i >= 0 {
+			word = rest[:i]
+		}
+		if !m.set(word) {
+			break
+		}
+		rest = strings.TrimLeft(rest[len(word):], " ")
+	}
+	return m, rest
+}
+
+// splitMailBrackets takes mail commands from a "[red big] " prefix of s.
+// s is returned as it is unless everything in the brackets is a command.
+func splitMailBrackets(s string) (MailCommand, string) {
+	end := strings.IndexByte(s, ']')
+	if !strings.HasPrefix(s, "[") || end < 2 {
+		return MailCommand{}, s
+	}
+	mail, rest := parseMailPrefix(s[1:end])
+	if rest != "" {
+		return MailCommand{}, s
+	}
+	return mail, strings.TrimLeft(s[end+1:], " ")
+}
+
+func (m *MailCommand) set(cmd string) bool {
+	switch {
+	case cmd == "184":
+		m.Anonymous = true
+	case mailColors[cmd] != 0 || mailColorCodeReg.MatchString(cmd):
+		m.Color = cmd
+	case mailPositions[cmd]:
+		m.Position = cmd
+	case mailSizes[cmd]:
+		m.Size = cmd
+	default:
+		return false
+	}
+	return true
+}
+
+// String joins the commands back into a mail attribute.
+func (m MailCommand) String() string {
+	var cmds []string
+	if m.Anonymous {
+		cmds = append(cmds, "184")
+	}
+	for _, c := range []string{m.Color, m.Position, m.Size} {
+		if c != "" {
+			cmds = append(cmds, c)
+		}
+	}
+	return strings.Join(append(cmds, m.Others...), " ")
+}
+
+// Attr returns the termbox color of the comment, bold if it is big.
+func (m MailCommand) Attr() termbox.Attribute {
+	attr := termbox.ColorDefault
+	if c, ok := mailColors[m.Color]; ok {
+		attr = c
+	} else if mailColorCodeReg.MatchString(m.Color) {
+		attr = nearestColor(m.Color)
+	}
+	if m.Size == "big" {
+		attr |= termbox.AttrBold
+	}
+	return attr
+}
+
+// nearestColor maps #RRGGBB to one of the eight terminal colors.
+func nearestColor(code string) termbox.Attribute {
+	rgb, _ := strconv.ParseUint(code[1:], 16, 32)
+	i := 0
+	if rgb>>16&0xff >= 0x80 {
+		i |= 1
+	}
+	if rgb>>8&0xff >= 0x80 {
+		i |= 2
+	}
+	if rgb&0xff >= 0x80 {
+		i |= 4
+	}
+	return termbox.ColorBlack + termbox.Attribute(i)
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"github.com/nsf/termbox-go"
 	"os"
@@ -9,9 +11,13 @@ import (
 	"time"
 )
 
+var liveIDReg = regexp.MustCompile(`lv\d+`)
+
 var (
 	confPath    = os.Getenv("HOME") + "/.config/kome"
 	accountPath = confPath + "/account.json"
+	configPath  = confPath + "/config.json"
+	historyPath = confPath + "/history"
 	dbPath      = confPath + "/user.sqlite"
 )
 
@@ -19,72 +25,241 @@ func stdErr(err error) {
 	fmt.Fprintf(os.Stderr, "kome: %v\n", err)
 }
 func usage() {
-	fmt.Fprintf(os.Stdout, "Usage: kome \x1b[4mURL or lv***\x1b[0m\n")
+	fmt.Fprintf(os.Stdout, "Usage: kome [--profile \x1b[4mNAME\x1b[0m] [--json] [--record \x1b[4mFILE\x1b[0m] \x1b[4mURL or lv***\x1b[0m...\n")
+	fmt.Fprintf(os.Stdout, "       kome login [--profile \x1b[4mNAME\x1b[0m] [--no-save-password]\n")
+	fmt.Fprintf(os.Stdout, "       kome login [--profile \x1b[4mNAME\x1b[0m] --from-browser \x1b[4mCOOKIE FILE\x1b[0m\n")
+	fmt.Fprintf(os.Stdout, "       kome replay [--speed 1|4|instant] \x1b[4mFILE\x1b[0m\n")
 }
 
 func main() {
 	runtime.GOMAXPROCS(runtime.NumCPU())
 
-	if len(os.Args) != 2 {
-		usage()
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		replayMain(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "login" {
+		loginMain(os.Args[2:])
 		return
 	}
 
-	liveID := regexp.MustCompile(`lv\d+`).FindString(os.Args[1])
-	if liveID == "" {
+	jsonMode := flag.Bool("json", false, "print comments to stdout as JSON Lines instead of starting the viewer")
+	recordPath := flag.String("record", "", "save the raw comment stream to this file for kome replay")
+	profile := flag.String("profile", "", "use the account of this profile (see kome login)")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
 		usage()
 		return
 	}
 
-	// load account
-	account, err := LoadAccount(accountPath)
+	var liveIDs []string
+	for _, arg := range flag.Args() {
+		liveID := liveIDReg.FindString(arg)
+		if liveID == "" {
+			usage()
+			return
+		}
+		liveIDs = append(liveIDs, liveID)
+	}
+	if len(liveIDs) > 1 && (*jsonMode || *recordPath != "") {
+		stdErr(errors.New("--json and --record take only one broadcast"))
+		return
+	}
+
+	// load key bindings, theme and input history
+	conf, err := LoadConfig(configPath)
+	if err != nil {
+		stdErr(err)
+		return
+	}
+	hist, err := LoadHistory(historyPath)
+	if err != nil {
+		stdErr(err)
+		return
+	}
+
+	// load account of the profile
+	path, err := profileAccountPath(*profile)
 	if err != nil {
 		stdErr(err)
 		return
 	}
-	if err := account.HeartBeat(); err != nil {
-		if err := account.Login(); err != nil {
+	account, err := LoadAccount(path)
+	if err != nil {
+		stdErr(err)
+		return
+	}
+	if err := loginAccount(account, path, *profile); err != nil {
+		stdErr(err)
+		return
+	}
+
+	// open and migrate user database
+	// create user repo
+	db, err := OpenWithMigrate(dbPath)
+	if err != nil {
+		stdErr(err)
+		return
+	}
+	defer db.Close()
+	repo := NewUserRepo(db, account.Endpoints)
+	if err := repo.LoadKotehan(); err != nil {
+		stdErr(err)
+		return
+	}
+
+	log := NewCommentLog(db)
+	defer log.Close()
+
+	ng, err := LoadNGList(db)
+	if err != nil {
+		stdErr(err)
+		return
+	}
+
+	// load and connect lives, each resuming after the comments
+	// logged by an earlier session
+	lives := make([]*Live, len(liveIDs))
+	histories := make([][]Chat, len(liveIDs))
+	for i, liveID := range liveIDs {
+		lv, history, err := loadLive(account, repo, log, liveID)
+		if err != nil {
 			stdErr(err)
 			return
 		}
-		if err := account.HeartBeat(); err != nil {
+		lv.SetSendInterval(conf.SendInterval)
+		if *recordPath != "" {
+			rec, err := NewRecorder(*recordPath, liveID, lv.Status)
+			if err != nil {
+				stdErr(err)
+				return
+			}
+			defer rec.Close()
+			lv.Record(rec)
+		}
+		if err := lv.Connect(time.Second * 5); err != nil {
 			stdErr(err)
 			return
 		}
-		if err := account.SaveTo(accountPath); err != nil {
+		defer lv.Close()
+		lives[i], histories[i] = lv, history
+	}
+	lv := lives[0]
+
+	// serve comments to streaming software
+	var overlay *Overlay
+	if conf.Overlay.Port != 0 {
+		overlay, err = NewOverlay(conf.Overlay.Port, conf.Overlay.CSS, ng)
+		if err != nil {
 			stdErr(err)
 			return
 		}
+		defer overlay.Close()
 	}
 
-	// open and migrate user database
-	// create user repo
-	db, err := OpenWithMigrate(dbPath)
+	// run commands such as text-to-speech for every comment
+	hooks := NewHooks(conf.Hooks, ng)
+
+	// stream comments to stdout without termbox
+	if *jsonMode {
+		headless := NewHeadless(lv, log, os.Stdout)
+		headless.overlay = overlay
+		headless.hooks = hooks
+		if err := headless.WriteHistory(histories[0]); err != nil {
+			stdErr(err)
+			return
+		}
+		if err := headless.Loop(); err != nil {
+			stdErr(err)
+		}
+		return
+	}
+
+	// init termbox
+	if err := termbox.Init(); err != nil {
+		stdErr(err)
+		return
+	}
+	defer termbox.Close()
+
+	// create view and start kome!
+	view := NewView(lv, conf, log, ng, hist)
+	view.overlay = overlay
+	view.hooks = hooks
+	view.preload(histories[0])
+	for i := 1; i < len(lives); i++ {
+		view.addTab(lives[i], histories[i], false)
+	}
+	view.Loop()
+}
+
+func replayMain(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	speed := fs.String("speed", "1", "playback speed: 1, 4 or instant")
+	fs.Usage = usage
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		usage()
+		return
+	}
+
+	conf, err := LoadConfig(configPath)
 	if err != nil {
 		stdErr(err)
+		return
+	}
+	hist, err := LoadHistory(historyPath)
+	if err != nil {
+		stdErr(err)
+		return
 	}
-	repo := NewUserRepo(db)
 
-	// load and connect live
-	lv := NewLive(account, repo, liveID)
-	if err := lv.LoadPlayerStatus(); err != nil {
+	player, err := OpenPlayer(fs.Arg(0))
+	if err != nil {
 		stdErr(err)
 		return
 	}
+	defer player.Close()
+	if err := player.SetSpeed(*speed); err != nil {
+		stdErr(err)
+		return
+	}
+
+	// user names are still resolved from the database and the API
+	db, err := OpenWithMigrate(dbPath)
+	if err != nil {
+		stdErr(err)
+		return
+	}
+	defer db.Close()
+	repo := NewUserRepo(db, DefaultEndpoints)
+	if err := repo.LoadKotehan(); err != nil {
+		stdErr(err)
+		return
+	}
+	ng, err := LoadNGList(db)
+	if err != nil {
+		stdErr(err)
+		return
+	}
+
+	lv := player.Live(repo)
 	if err := lv.Connect(time.Second * 5); err != nil {
 		stdErr(err)
 		return
 	}
 	defer lv.Close()
 
-	// init termbox
 	if err := termbox.Init(); err != nil {
 		stdErr(err)
 		return
 	}
 	defer termbox.Close()
 
-	// create view and start kome!
-	view := NewView(lv)
+	view := NewView(lv, conf, nil, ng, hist)
+	view.player = player
 	view.Loop()
 }
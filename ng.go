@@ -0,0 +1,203 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	NGUser  = "user"
+	NGWord  = "word"
+	NGRegex = "regex"
+)
+
+// NGList mutes comments by user ID, word or regex. Entries are stored in
+// the user database, except 184 IDs, which change every broadcast and are
+// only kept for the running session.
+type NGList struct {
+	db *sql.DB
+
+	mu      sync.Mutex
+	users   map[string]bool
+	words   []string
+	regexes []*regexp.Regexp
+}
+
+func LoadNGList(db *sql.DB) (*NGList, error) {
+	n := &NGList{
+		db:    db,
+		users: make(map[string]bool),
+	}
+
+	rows, err := db.Query("select kind, value from ng")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var kind, value string
+		if err := rows.Scan(&kind, &value); err != nil {
+			return nil, err
+		}
+		// a regex that no longer compiles is skipped rather than fatal
+		n.add(kind, value)
+	}
+	return n, rows.Err()
+}
+
+// add keeps one entry per value, like the ng table does.
+func (n *NGList) add(kind, value string) error {
+	switch kind {
+	case NGUser:
+		n.users[value] = true
+	case NGWord:
+		for _, w := range n.words {
+			if w == value {
+				return nil
+			}
+		}
+		n.words = append(n.words, value)
+	case NGRegex:
+		for _, re := range n.regexes {
+			if re.String() == value {
+				return nil
+			}
+		}
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return err
+		}
+		n.regexes = append(n.regexes, re)
+	default:
+		return fmt.Errorf("unknown NG kind %v", kind)
+	}
+	return nil
+}
+
+func (n *NGList) Add(kind, value string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if err := n.add(kind, value); err != nil {
+		return err
+	}
+	if kind == NGUser && !rawUserIDReg.MatchString(value) {
+		return nil
+	}
+	_, err := n.db.Exec("insert or ignore into ng(kind, value) values(?, ?)", kind, value)
+	return err
+}
+
+func (n *NGList) Remove(kind, value string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	switch kind {
+	case NGUser:
+		delete(n.users, value)
+	case NGWord:
+		for i, w := range n.words {
+			if w == value {
+				n.words = append(n.words[:i], n.words[i+1:]...)
+				break
+			}
+		}
+	case NGRegex:
+		for i, re := range n.regexes {
+			if re.String() == value {
+				n.regexes = append(n.regexes[:i], n.regexes[i+1:]...)
+				break
+			}
+		}
+	default:
+		return fmt.Errorf("unknown NG kind %v", kind)
+	}
+
+	_, err := n.db.Exec("delete from ng where kind = ? and value = ?", kind, value)
+	return err
+}
+
+// Match reports whether kome should be muted. Operator comments never are.
+func (n *NGList) Match(kome Chat) bool {
+	if n == nil || kome.IsOperator() {
+		return false
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.users[kome.UserID] {
+		return true
+	}
+	for _, w := range n.words {
+		if strings.Contains(kome.Comment, w) {
+			return true
+		}
+	}
+	for _, re := range n.regexes {
+		if re.MatchString(kome.Comment) {
+			return true
+		}
+	}
+	return false
+}
+
+// execNG runs ng, ngword, ngregex, ngdel and ngmode commands.
+// ng and ngdel without arguments apply to the user of the selected row.
+func (v *View) execNG(cmd string) error {
+	name, arg := cmd, ""
+	if p := strings.Index(cmd, " "); p >= 0 {
+		name, arg = cmd[0:p], strings.TrimSpace(cmd[p+1:])
+	}
+
+	var err error
+	switch name {
+	case "ng", "ngdel":
+		kind, value := NGUser, arg
+		if arg == "" {
+			if len(v.shown) == 0 {
+				return fmt.Errorf("no comment selected")
+			}
+			value = v.komes[v.shown[v.ptr]].UserID
+		} else if name == "ngdel" {
+			if p := strings.Index(arg, " "); p >= 0 {
+				kind, value = arg[0:p], arg[p+1:]
+			}
+		}
+		if name == "ng" {
+			err = v.ng.Add(kind, value)
+		} else {
+			err = v.ng.Remove(kind, value)
+		}
+	case "ngword", "ngregex":
+		if arg == "" {
+			return fmt.Errorf("usage: :%s VALUE", name)
+		}
+		if name == "ngword" {
+			err = v.ng.Add(NGWord, arg)
+		} else {
+			err = v.ng.Add(NGRegex, arg)
+		}
+	case "ngmode":
+		switch arg {
+		case "hide":
+			v.ngMask = false
+		case "mask":
+			v.ngMask = true
+		default:
+			err = fmt.Errorf("usage: :ngmode hide|mask")
+		}
+	default:
+		err = fmt.Errorf("unknown command %v", name)
+	}
+	if err != nil {
+		return err
+	}
+
+	v.setFilter(v.filter)
+	return nil
+}
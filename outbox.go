@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"github.com/nsf/termbox-go"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxOutboxRows is the most rows the outbox pane takes.
+	maxOutboxRows = 5
+	// outboxKeep is how long a finished comment stays in the pane.
+	outboxKeep = 5 * time.Second
+)
+
+var outboxColors = map[string]termbox.Attribute{
+	SendQueued:    termbox.ColorDefault,
+	SendSending:   termbox.ColorYellow,
+	SendSent:      termbox.ColorGreen,
+	SendFailed:    termbox.ColorRed,
+	SendCancelled: termbox.ColorDefault,
+}
+
+// outgoing is a comment being sent as shown in the outbox pane.
+type outgoing struct {
+	SendResult
+	done time.Time
+}
+
+func (o *outgoing) finished() bool {
+	return o.State == SendSent || o.State == SendFailed || o.State == SendCancelled
+}
+
+// updateOutbox records a new state of a comment being sent.
+func (v *View) updateOutbox(r *SendResult) {
+	var o *outgoing
+	for _, q := range v.outbox {
+		if q.ID == r.ID {
+			o = q
+			break
+		}
+	}
+	if o == nil {
+		o = &outgoing{}
+		v.outbox = append(v.outbox, o)
+	}
+
+	atBottom := v.calcEnd() == len(v.shown)
+	o.SendResult = *r
+	if o.finished() {
+		o.done = time.Now()
+	}
+	if atBottom {
+		v.followBottom()
+	}
+}
+
+// pruneOutbox drops comments finished more than outboxKeep ago.
+func (v *View) pruneOutbox() {
+	kept := v.outbox[:0]
+	for _, o := range v.outbox {
+		if !o.finished() || time.Since(o.done) < outboxKeep {
+			kept = append(kept, o)
+		}
+	}
+	v.outbox = kept
+}
+
+func (v *View) outboxRows() int {
+	n := len(v.outbox)
+	if n > maxOutboxRows {
+		n = maxOutboxRows
+	}
+	if v.height-2-n < 1 {
+		return 0
+	}
+	return n
+}
+
+// cancelSend cancels the queued comment with the ID in arg,
+// or the last queued one if arg is empty.
+func (v *View) cancelSend(arg string) error {
+	id := 0
+	if arg != "" {
+		n, err := strconv.Atoi(strings.TrimPrefix(arg, "#"))
+		if err != nil {
+			return fmt.Errorf("invalid comment ID %v", arg)
+		}
+		id = n
+	} else {
+		for _, o := range v.outbox {
+			if o.State == SendQueued {
+				id = o.ID
+			}
+		}
+		if id == 0 {
+			return fmt.Errorf("no queued comment")
+		}
+	}
+
+	if !v.live.CancelKome(id) {
+		return fmt.Errorf("comment #%d is not queued", id)
+	}
+	return nil
+}
+
+// drawOutbox draws the newest comments being sent on outboxRows rows from y.
+func (v *View) drawOutbox(y int) {
+	rows := v.outboxRows()
+	for _, o := range v.outbox[len(v.outbox)-rows:] {
+		line := fmt.Sprintf("#%d %-9s %s", o.ID, o.State, o.Comment)
+		if o.State == SendSent {
+			line = fmt.Sprintf("#%d %-9s %s (No.%d)", o.ID, o.State, o.Comment, o.No)
+		}
+		if o.Error != "" {
+			line += ": " + o.Error
+		}
+
+		x := 0
+		for _, c := range line {
+			if x+width(c) > v.width {
+				break
+			}
+			termbox.SetCell(x, y, c, outboxColors[o.State], termbox.ColorDefault)
+			x += width(c)
+		}
+		for ; x < v.width; x++ {
+			termbox.SetCell(x, y, ' ', termbox.ColorDefault, termbox.ColorDefault)
+		}
+		y++
+	}
+}
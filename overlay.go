@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// overlayBuffer is how many messages a slow client may fall behind
+// before it starts missing them.
+const overlayBuffer = 64
+
+const defaultOverlayCSS = `body { margin: 0; font-family: sans-serif; color: #fff; background: transparent; }
+#comments { position: fixed; bottom: 0; width: 100%; }
+.comment { padding: 4px 8px; text-shadow: 0 0 3px #000; }
+.name { color: #8f8; margin-right: 8px; }
+.operator .comment-text { color: #ff8; }
+`
+
+const overlayHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>kome</title>
+<link rel="stylesheet" href="/style.css">
+</head>
+<body>
+<div id="comments"></div>
+<script>
+var max = 20;
+var list = document.getElementById("comments");
+var source = new EventSource("/events" + location.search);
+source.addEventListener("chat", function(e) {
+	var c = JSON.parse(e.data);
+	var row = document.createElement("div");
+	row.className = "comment" + (c.operator ? " operator" : "");
+	row.dataset.userId = c.user_id;
+	var name = document.createElement("span");
+	name.className = "name";
+	name.textContent = c.name;
+	var text = document.createElement("span");
+	text.className = "comment-text";
+	text.textContent = c.comment;
+	row.appendChild(name);
+	row.appendChild(text);
+	list.appendChild(row);
+	while (list.children.length > max) {
+		list.removeChild(list.firstChild);
+	}
+});
+source.addEventListener("user", function(e) {
+	var u = JSON.parse(e.data);
+	var rows = list.querySelectorAll(".comment");
+	for (var i = 0; i < rows.length; i++) {
+		if (rows[i].dataset.userId === u.user_id) {
+			rows[i].querySelector(".name").textContent = u.name;
+		}
+	}
+});
+</script>
+</body>
+</html>
+`
+
+type overlayChat struct {
+	LiveID   string `json:"live_id"`
+	No       int    `json:"no"`
+	UserID   string `json:"user_id"`
+	Name     string `json:"name"`
+	Comment  string `json:"comment"`
+	Is184    bool   `json:"is_184"`
+	Operator bool   `json:"operator"`
+}
+
+type overlayUser struct {
+	UserID string `json:"user_id"`
+	Name   string `json:"name"`
+}
+
+// Overlay serves a page for streaming software that shows the comments
+// Push receives, sent to it as Server-Sent Events.
+type Overlay struct {
+	ng  *NGList
+	css []byte
+	ln  net.Listener
+
+	mu      sync.Mutex
+	clients map[chan []byte]string // the broadcast each client asked for, or ""
+}
+
+// NewOverlay starts serving on localhost. The default style is used if cssPath is empty.
+func NewOverlay(port int, cssPath string, ng *NGList) (*Overlay, error) {
+	css := []byte(defaultOverlayCSS)
+	if cssPath != "" {
+		b, err := ioutil.ReadFile(cssPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open overlay css %v", cssPath)
+		}
+		css = b
+	}
+
+	ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on port %d for the overlay", port)
+	}
+
+	o := &Overlay{
+		ng:      ng,
+		css:     css,
+		ln:      ln,
+		clients: make(map[chan []byte]string),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", o.page)
+	mux.HandleFunc("/style.css", o.style)
+	mux.HandleFunc("/events", o.events)
+	go http.Serve(ln, mux)
+
+	return o, nil
+}
+
+func (o *Overlay) URL() string {
+	return "http://" + o.ln.Addr().String() + "/"
+}
+
+func (o *Overlay) Close() error {
+	return o.ln.Close()
+}
+
+// Push sends kome of the broadcast liveID to every page showing it,
+// unless it is muted.
+func (o *Overlay) Push(liveID string, kome Chat) {
+	if o == nil || o.ng.Match(kome) {
+		return
+	}
+	o.broadcast("chat", liveID, overlayChat{
+		LiveID:   liveID,
+		No:       kome.No,
+		UserID:   kome.UserID,
+		Name:     kome.User.Name,
+		Comment:  commentText(kome),
+		Is184:    !kome.User.IsRawUser,
+		Operator: kome.IsOperator(),
+	})
+}
+
+// PushUser renames the comments of a user whose name was resolved late.
+func (o *Overlay) PushUser(up UserUpdate) {
+	if o == nil {
+		return
+	}
+	// names are the same in every broadcast
+	o.broadcast("user", "", overlayUser{up.UserID, up.User.Name})
+}
+
+// broadcast sends v to the clients of liveID, or to all of them if liveID is empty.
+func (o *Overlay) broadcast(event, liveID string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	msg := []byte(fmt.Sprintf("event: %s\ndata: %s\n\n", event, data))
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for ch, live := range o.clients {
+		if liveID != "" && live != "" && live != liveID {
+			continue
+		}
+		select {
+		case ch <- msg:
+		default:
+			// the client is too slow, drop the message
+		}
+	}
+}
+
+func (o *Overlay) page(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, overlayHTML)
+}
+
+func (o *Overlay) style(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/css; charset=utf-8")
+	w.Write(o.css)
+}
+
+func (o *Overlay) events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	ch := make(chan []byte, overlayBuffer)
+	o.mu.Lock()
+	o.clients[ch] = r.URL.Query().Get("live")
+	o.mu.Unlock()
+	defer func() {
+		o.mu.Lock()
+		delete(o.clients, ch)
+		o.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case msg := <-ch:
+			if _, err := w.Write(msg); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOverlayFiltersByLive(t *testing.T) {
+	o, err := NewOverlay(0, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o.Close()
+
+	res, err := http.Get(o.URL() + "events?live=lv2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	// the client is registered once the headers are flushed
+	o.Push("lv1", Chat{No: 1, Comment: "other broadcast"})
+	o.Push("lv2", Chat{No: 1, Comment: "this broadcast"})
+
+	lines := make(chan string)
+	go func() {
+		sc := bufio.NewScanner(res.Body)
+		for sc.Scan() {
+			if strings.HasPrefix(sc.Text(), "data: ") {
+				lines <- sc.Text()
+			}
+		}
+	}()
+	select {
+	case line := <-lines:
+		if !strings.Contains(line, `"live_id":"lv2"`) || !strings.Contains(line, "this broadcast") {
+			t.Errorf("got %v", line)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("no chat event")
+	}
+}
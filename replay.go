@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// A recording starts with a JSON header line followed by frames of
+// receive time (unix nanoseconds), length and the raw bytes read from
+// the message server.
+type recordHeader struct {
+	LiveID string       `json:"live_id"`
+	Status PlayerStatus `json:"status"`
+}
+
+type Recorder struct {
+	mu sync.Mutex
+	f  *os.File
+	w  *bufio.Writer
+}
+
+func NewRecorder(path string, liveID string, status PlayerStatus) (*Recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create record file %v", path)
+	}
+
+	w := bufio.NewWriter(f)
+	if err := json.NewEncoder(w).Encode(recordHeader{liveID, status}); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &Recorder{f: f, w: w}, nil
+}
+
+func (r *Recorder) Record(b []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var head [12]byte
+	binary.BigEndian.PutUint64(head[0:8], uint64(time.Now().UnixNano()))
+	binary.BigEndian.PutUint32(head[8:12], uint32(len(b)))
+	if _, err := r.w.Write(head[:]); err != nil {
+		return err
+	}
+	_, err := r.w.Write(b)
+	return err
+}
+
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.w.Flush(); err != nil {
+		r.f.Close()
+		return err
+	}
+	return r.f.Close()
+}
+
+// replaySpeeds are cycled by Player.NextSpeed; 0 means instant.
+var replaySpeeds = []float64{1, 4, 0}
+
+// Player feeds a recording to Live through an in-memory connection,
+// keeping the original timing scaled by the current speed.
+type Player struct {
+	Header recordHeader
+
+	f *os.File
+	r *bufio.Reader
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	speed  int
+	paused bool
+	dialed bool
+}
+
+func OpenPlayer(path string) (*Player, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open record file %v", path)
+	}
+
+	p := &Player{f: f, r: bufio.NewReader(f)}
+	p.cond = sync.NewCond(&p.mu)
+
+	line, err := p.r.ReadBytes('\n')
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to read record file %v", path)
+	}
+	if err := json.Unmarshal(line, &p.Header); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to parse record file %v", path)
+	}
+	return p, nil
+}
+
+// Live returns a Live that reads from the recording instead of the network.
+func (p *Player) Live(repo *UserRepo) *Live {
+	lv := NewLive(nil, repo, p.Header.LiveID)
+	lv.Status = p.Header.Status
+	lv.dial = p.dial
+	return lv
+}
+
+func (p *Player) Close() error {
+	return p.f.Close()
+}
+
+func (p *Player) dial() (net.Conn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.dialed {
+		return nil, errEnded
+	}
+	p.dialed = true
+
+	client, server := net.Pipe()
+	// the thread request and keepalives have nowhere to go
+	go io.Copy(ioutil.Discard, server)
+	go p.play(server)
+	return client, nil
+}
+
+func (p *Player) play(conn net.Conn) {
+	defer conn.Close()
+
+	var prev int64
+	for {
+		at, b, err := p.readFrame()
+		if err != nil {
+			return
+		}
+		if prev != 0 {
+			p.wait(time.Duration(at - prev))
+		}
+		prev = at
+
+		if _, err := conn.Write(b); err != nil {
+			return
+		}
+	}
+}
+
+func (p *Player) readFrame() (int64, []byte, error) {
+	var head [12]byte
+	if _, err := io.ReadFull(p.r, head[:]); err != nil {
+		return 0, nil, err
+	}
+	b := make([]byte, binary.BigEndian.Uint32(head[8:12]))
+	if _, err := io.ReadFull(p.r, b); err != nil {
+		return 0, nil, err
+	}
+	return int64(binary.BigEndian.Uint64(head[0:8])), b, nil
+}
+
+// wait sleeps for d of recorded time in small steps,
+// so pausing and changing speed take effect right away.
+func (p *Player) wait(d time.Duration) {
+	for d > 0 {
+		p.mu.Lock()
+		for p.paused {
+			p.cond.Wait()
+		}
+		speed := replaySpeeds[p.speed]
+		p.mu.Unlock()
+
+		if speed == 0 {
+			return
+		}
+
+		step := time.Duration(float64(100*time.Millisecond) * speed)
+		if step > d {
+			step = d
+		}
+		time.Sleep(time.Duration(float64(step) / speed))
+		d -= step
+	}
+}
+
+func (p *Player) TogglePause() {
+	p.mu.Lock()
+	p.paused = !p.paused
+	p.mu.Unlock()
+	p.cond.Broadcast()
+}
+
+func (p *Player) NextSpeed() {
+	p.mu.Lock()
+	p.speed = (p.speed + 1) % len(replaySpeeds)
+	p.mu.Unlock()
+}
+
+func (p *Player) String() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.paused {
+		return "replay paused"
+	}
+	if speed := replaySpeeds[p.speed]; speed > 0 {
+		return fmt.Sprintf("replay %gx", speed)
+	}
+	return "replay instant"
+}
+
+// SetSpeed selects one of replaySpeeds by name: "1", "4" or "instant".
+func (p *Player) SetSpeed(name string) error {
+	for i, speed := range replaySpeeds {
+		if name == fmt.Sprintf("%g", speed) || name == "instant" && speed == 0 {
+			p.mu.Lock()
+			p.speed = i
+			p.mu.Unlock()
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown replay speed %v", name)
+}
@@ -5,40 +5,120 @@ import (
 	"encoding/xml"
 	"errors"
 	"fmt"
-	_ "github.com/mattn/go-sqlite3"
 	"net/http"
 	"regexp"
 	"strconv"
+	"sync"
+	"time"
 )
 
-const createUserTable = `create table if not exists user(id integer primary key, name varchar(255))`
+var rawUserIDReg = regexp.MustCompile(`^\d+$`)
 
-func OpenWithMigrate(path string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", path)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open user database %v", path)
-	}
-	if _, err := db.Exec(createUserTable); err != nil {
-		return nil, err
-	}
-	return db, nil
+const (
+	resolveWorkers = 4
+	resolveQueue   = 256
+	userCacheTTL   = 7 * 24 * time.Hour
+)
+
+type UserRepo struct {
+	db     *sql.DB
+	apiURL string
+
+	mu      sync.Mutex
+	mp      map[int64]User
+	pending map[int64]bool
+	kotehan map[string]string
+
+	jobs     chan int64
+	UpdateCh chan UserUpdate
 }
 
-var rawUserIDReg = regexp.MustCompile(`^\d+$`)
+// UserUpdate is sent when the name of UserID changes after its comments were delivered.
+type UserUpdate struct {
+	UserID string `json:"user_id"`
+	User   User   `json:"user"`
+}
 
-type UserRepo struct {
-	db *sql.DB
-	mp map[int64]User
+// userWait is how long a comment is held back waiting for the name of
+// its user before it is used with the raw ID as the name.
+const userWait = 2 * time.Second
+
+// unresolved reports whether the name of the user is still the raw ID
+// that UserRepo.Get returns while looking it up.
+func unresolved(kome Chat) bool {
+	return kome.User.IsRawUser && kome.User.Name == kome.UserID
+}
+
+type heldKome struct {
+	liveID string
+	kome   Chat
+	at     time.Time
+}
+
+// userWaitQueue holds comments, in the order they arrived, until the
+// names of their users are resolved or userWait passes.
+type userWaitQueue struct {
+	held []heldKome
+}
+
+func (q *userWaitQueue) push(liveID string, kome Chat) {
+	q.held = append(q.held, heldKome{liveID, kome, time.Now()})
+}
+
+// rename sets the resolved user on the held comments of up.UserID.
+func (q *userWaitQueue) rename(up UserUpdate) {
+	for i := range q.held {
+		if q.held[i].kome.UserID == up.UserID {
+			q.held[i].kome.User = up.User
+		}
+	}
 }
 
-func NewUserRepo(db *sql.DB) *UserRepo {
-	return &UserRepo{
-		db: db,
-		mp: make(map[int64]User),
+// pop takes the oldest comment if it is ready, or any held one if all is set.
+func (q *userWaitQueue) pop(all bool) (heldKome, bool) {
+	if len(q.held) == 0 {
+		return heldKome{}, false
+	}
+	k := q.held[0]
+	if !all && unresolved(k.kome) && time.Since(k.at) < userWait {
+		return heldKome{}, false
+	}
+	q.held = q.held[1:]
+	return k, true
+}
+
+func NewUserRepo(db *sql.DB, endpoints Endpoints) *UserRepo {
+	r := &UserRepo{
+		db:       db,
+		apiURL:   endpoints.UserAPI,
+		mp:       make(map[int64]User),
+		pending:  make(map[int64]bool),
+		kotehan:  make(map[string]string),
+		jobs:     make(chan int64, resolveQueue),
+		UpdateCh: make(chan UserUpdate, 1024),
+	}
+	for i := 0; i < resolveWorkers; i++ {
+		go r.resolve()
 	}
+	return r
 }
 
+// Get never blocks. A user that is not cached yet is returned with
+// the raw ID as its name and looked up in the background; the resolved
+// user is sent on UpdateCh. A kotehan overrides any other name.
 func (r *UserRepo) Get(ID string) User {
+	u := r.get(ID)
+
+	r.mu.Lock()
+	if name, ok := r.kotehan[ID]; ok {
+		u.Name = name
+	}
+	r.mu.Unlock()
+
+	return u
+}
+
+func (r *UserRepo) get(ID string) User {
 	if !rawUserIDReg.MatchString(ID) {
 		return User{
 			IsRawUser: false,
@@ -56,8 +136,19 @@ func (r *UserRepo) Get(ID string) User {
 		}
 	}
 
-	u, err := r.getByRawID(id)
-	if err != nil {
+	r.mu.Lock()
+	u, ok := r.mp[id]
+	if !ok && !r.pending[id] {
+		select {
+		case r.jobs <- id:
+			r.pending[id] = true
+		default:
+			// queue is full, try again on the next comment
+		}
+	}
+	r.mu.Unlock()
+
+	if !ok {
 		return User{
 			IsRawUser: true,
 			ID:        id,
@@ -69,37 +160,73 @@ func (r *UserRepo) Get(ID string) User {
 	return u
 }
 
+func (r *UserRepo) resolve() {
+	for id := range r.jobs {
+		u, err := r.getByRawID(id)
+
+		r.mu.Lock()
+		delete(r.pending, id)
+		r.mu.Unlock()
+
+		if err != nil {
+			continue
+		}
+
+		r.notify(strconv.FormatInt(u.ID, 10))
+	}
+}
+
+func (r *UserRepo) notify(ID string) {
+	select {
+	case r.UpdateCh <- UserUpdate{ID, r.Get(ID)}:
+	default:
+	}
+}
+
 func (r *UserRepo) writeToDB(user User) error {
-	_, err := r.db.Exec("insert into user values(?, ?)", user.ID, user.Name)
+	_, err := r.db.Exec(
+		`insert into user(id, name, fetched_at) values(?, ?, ?)
+		on conflict(id) do update set name = excluded.name, fetched_at = excluded.fetched_at`,
+		user.ID, user.Name, time.Now().Unix(),
+	)
 	return err
 }
 
-func (r *UserRepo) readFromDB(id int64) (User, error) {
-	row := r.db.QueryRow("select from user where id = ?", id)
+func (r *UserRepo) readFromDB(id int64) (User, time.Time, error) {
+	row := r.db.QueryRow("select id, name, fetched_at from user where id = ?", id)
 	var user User
-	err := row.Scan(&user.ID, &user.Name)
-	return user, err
+	var fetchedAt int64
+	err := row.Scan(&user.ID, &user.Name, &fetchedAt)
+	return user, time.Unix(fetchedAt, 0), err
+}
+
+func (r *UserRepo) cache(user User) {
+	r.mu.Lock()
+	r.mp[user.ID] = user
+	r.mu.Unlock()
 }
 
 func (r *UserRepo) getByRawID(id int64) (User, error) {
-	if user, ok := r.mp[id]; ok {
-		return user, nil
-	}
-	if user, err := r.readFromDB(id); err == nil {
-		r.mp[id] = user
-		return user, nil
+	stored, fetchedAt, dbErr := r.readFromDB(id)
+	if dbErr == nil && time.Since(fetchedAt) < userCacheTTL {
+		r.cache(stored)
+		return stored, nil
 	}
-	user, err := getUserFromAPI(id)
+	user, err := getUserFromAPI(r.apiURL, id)
 	if err == nil {
-		r.mp[id] = user
+		r.cache(user)
 		r.writeToDB(user)
 		return user, nil
 	}
+	// a stale name is better than none
+	if dbErr == nil {
+		r.cache(stored)
+		return stored, nil
+	}
 	return User{}, err
 }
-
-func getUserFromAPI(id int64) (User, error) {
-	u := fmt.Sprintf("http://api.ce.nicovideo.jp/api/v1/user.info?user_id=%d", id)
+func getUserFromAPI(base string, id int64) (User, error) {
+	u := fmt.Sprintf("%s/api/v1/user.info?user_id=%d", base, id)
 	res, err := http.Get(u)
 	if err != nil {
 		return User{}, err
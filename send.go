@@ -0,0 +1,319 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"html"
+	"time"
+)
+
+const sendTimeout = 10 * time.Second
+
+const chatResultPostKeyExpired = 4
+
+var chatResultMessages = map[int]string{
+	1: "rejected (duplicate or posting too fast)",
+	2: "thread not found",
+	3: "invalid ticket",
+	4: "post key expired",
+	5: "comments are locked",
+	6: "thread is read only",
+	8: "comment is too long",
+}
+
+func chatResultError(status int) error {
+	if status == 0 {
+		return nil
+	}
+	if msg, ok := chatResultMessages[status]; ok {
+		return errors.New(msg)
+	}
+	return errors.New("failed with unknown status")
+}
+
+// defaultSendInterval is the least time between two posts unless
+// SetSendInterval changes it.
+const defaultSendInterval = 2 * time.Second
+
+// states of a comment passed to SendKome
+const (
+	SendQueued    = "queued"
+	SendSending   = "sending"
+	SendSent      = "sent"
+	SendFailed    = "failed"
+	SendCancelled = "cancelled"
+)
+
+// SendResult reports each state a comment passed to SendKome goes through.
+type SendResult struct {
+	ID      int    `json:"id"`
+	State   string `json:"state"`
+	Comment string `json:"comment"`
+	No      int    `json:"no,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+type pendingSend struct {
+	id      int
+	comment string
+	mail    MailCommand
+	retried bool
+	expired bool // reported as failed, kept to absorb a late chat_result
+	timer   *time.Timer
+}
+
+// SetSendInterval sets the least time between two posts.
+func (lv *Live) SetSendInterval(d time.Duration) {
+	lv.mu.Lock()
+	lv.sendInterval = d
+	lv.mu.Unlock()
+}
+
+// SendKome queues a comment and returns its ID without waiting.
+// Every change of its state is kept for SendResults and signalled on SendCh.
+func (lv *Live) SendKome(comment string, mail MailCommand) (int, error) {
+	if lv.account == nil {
+		return 0, errors.New("cannot send comments without an account")
+	}
+
+	lv.mu.Lock()
+	lv.nextSendID++
+	p := &pendingSend{id: lv.nextSendID, comment: comment, mail: mail}
+	lv.outbox = append(lv.outbox, p)
+	lv.mu.Unlock()
+
+	lv.emitState(p, SendQueued)
+	select {
+	case lv.sendCh <- struct{}{}:
+	default:
+	}
+	return p.id, nil
+}
+
+// CancelKome removes a comment from the queue unless it is already being sent.
+func (lv *Live) CancelKome(id int) bool {
+	lv.mu.Lock()
+	var p *pendingSend
+	for i, q := range lv.outbox {
+		if q.id == id {
+			p = q
+			lv.outbox = append(lv.outbox[:i], lv.outbox[i+1:]...)
+			break
+		}
+	}
+	lv.mu.Unlock()
+
+	if p == nil {
+		return false
+	}
+	lv.emitState(p, SendCancelled)
+	return true
+}
+
+// sendLoop posts the queued comments in order, keeping sendInterval
+// between posts.
+func (lv *Live) sendLoop() {
+	var last time.Time
+	for {
+		select {
+		case <-lv.sig:
+			return
+		case <-lv.sendCh:
+		}
+
+		for {
+			lv.mu.Lock()
+			n, interval := len(lv.outbox), lv.sendInterval
+			lv.mu.Unlock()
+			if n == 0 {
+				break
+			}
+
+			if wait := interval - time.Since(last); wait > 0 {
+				select {
+				case <-lv.sig:
+					return
+				case <-time.After(wait):
+				}
+			}
+
+			// the head may have been cancelled while waiting
+			lv.mu.Lock()
+			if len(lv.outbox) == 0 {
+				lv.mu.Unlock()
+				break
+			}
+			p := lv.outbox[0]
+			lv.outbox = lv.outbox[1:]
+			lv.mu.Unlock()
+
+			lv.emitState(p, SendSending)
+			last = time.Now()
+			if err := lv.post(p); err != nil {
+				lv.emitSend(p, 0, err)
+			}
+		}
+	}
+}
+
+func (lv *Live) post(p *pendingSend) error {
+	postkey, err := lv.getPostKey()
+	if err != nil {
+		return err
+	}
+
+	vpos := lv.calcVpos()
+	lv.mu.Lock()
+	ticket := lv.thread.Ticket
+	lv.mu.Unlock()
+
+	kome := Chat{
+		Thread:  lv.Status.Ms.Thread,
+		Ticket:  ticket,
+		Vpos:    vpos,
+		PostKey: postkey,
+		UserID:  lv.Status.User.UserID,
+		Premium: lv.Status.User.IsPremium,
+		Mail:    p.mail.String(),
+		Comment: html.EscapeString(p.comment),
+	}
+
+	b, err := xml.Marshal(kome)
+	if err != nil {
+		return err
+	}
+
+	// chat_results come back in the order the chats were sent
+	lv.mu.Lock()
+	lv.pending = append(lv.pending, p)
+	p.timer = time.AfterFunc(sendTimeout, func() { lv.expire(p) })
+	lv.mu.Unlock()
+
+	if err := lv.write(b); err != nil {
+		lv.removePending(p)
+		return err
+	}
+	return nil
+}
+
+func (lv *Live) removePending(p *pendingSend) bool {
+	lv.mu.Lock()
+	defer lv.mu.Unlock()
+
+	for i, q := range lv.pending {
+		if q == p {
+			p.timer.Stop()
+			lv.pending = append(lv.pending[:i], lv.pending[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// expire fails p but leaves it in pending, so that a chat_result
+// arriving late is taken as its own instead of the next comment's.
+func (lv *Live) expire(p *pendingSend) {
+	lv.mu.Lock()
+	found := false
+	for _, q := range lv.pending {
+		if q == p && !p.expired {
+			p.expired = true
+			found = true
+			break
+		}
+	}
+	lv.mu.Unlock()
+
+	if found {
+		lv.emitSend(p, 0, errors.New("no response from server"))
+	}
+}
+
+// failPending fails the comments still waiting for a chat_result,
+// which never comes once the connection they were sent on is lost.
+func (lv *Live) failPending() {
+	lv.mu.Lock()
+	var lost []*pendingSend
+	for _, p := range lv.pending {
+		p.timer.Stop()
+		if !p.expired {
+			lost = append(lost, p)
+		}
+	}
+	lv.pending = nil
+	lv.mu.Unlock()
+
+	for _, p := range lost {
+		lv.emitSend(p, 0, errors.New("connection lost"))
+	}
+}
+
+func (lv *Live) handleChatResult(res ChatResult) {
+	lv.mu.Lock()
+	if len(lv.pending) == 0 {
+		lv.mu.Unlock()
+		return
+	}
+	p := lv.pending[0]
+	p.timer.Stop()
+	lv.pending = lv.pending[1:]
+	expired := p.expired
+	lv.mu.Unlock()
+
+	if expired {
+		return
+	}
+
+	// an expired post key is refreshed by posting again, once
+	if res.Status == chatResultPostKeyExpired && !p.retried {
+		p.retried = true
+		lv.mu.Lock()
+		lv.outbox = append([]*pendingSend{p}, lv.outbox...)
+		lv.mu.Unlock()
+
+		lv.emitState(p, SendQueued)
+		select {
+		case lv.sendCh <- struct{}{}:
+		default:
+		}
+		return
+	}
+
+	lv.emitSend(p, res.No, chatResultError(res.Status))
+}
+
+func (lv *Live) emitSend(p *pendingSend, no int, err error) {
+	r := &SendResult{ID: p.id, State: SendSent, Comment: p.comment, No: no}
+	if err != nil {
+		r.State = SendFailed
+		r.Error = err.Error()
+	}
+	lv.pushResult(r)
+}
+
+func (lv *Live) emitState(p *pendingSend, state string) {
+	lv.pushResult(&SendResult{ID: p.id, State: state, Comment: p.comment})
+}
+
+// pushResult keeps r for SendResults. Unlike events these are never
+// dropped: a comment whose last state is lost stays in the outbox.
+func (lv *Live) pushResult(r *SendResult) {
+	lv.mu.Lock()
+	lv.results = append(lv.results, r)
+	lv.mu.Unlock()
+
+	select {
+	case lv.SendCh <- struct{}{}:
+	default:
+	}
+}
+
+// SendResults returns the states reported since the last call, oldest first.
+func (lv *Live) SendResults() []*SendResult {
+	lv.mu.Lock()
+	defer lv.mu.Unlock()
+
+	rs := lv.results
+	lv.results = nil
+	return rs
+}
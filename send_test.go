@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func pendingTestLive(ids ...int) (*Live, []*pendingSend) {
+	lv := NewLive(nil, nil, "lv1")
+	var ps []*pendingSend
+	for _, id := range ids {
+		p := &pendingSend{id: id, comment: "c", timer: time.AfterFunc(time.Hour, func() {})}
+		ps = append(ps, p)
+	}
+	lv.pending = ps
+	return lv, ps
+}
+
+func TestLateChatResultIsNotCreditedToTheNextSend(t *testing.T) {
+	lv, ps := pendingTestLive(1, 2)
+
+	lv.expire(ps[0])
+	lv.handleChatResult(ChatResult{No: 55}) // the late reply to #1
+	lv.handleChatResult(ChatResult{No: 56})
+
+	var got []SendResult
+	for _, r := range lv.SendResults() {
+		got = append(got, *r)
+	}
+	want := []SendResult{
+		{ID: 1, State: SendFailed, Comment: "c", Error: "no response from server"},
+		{ID: 2, State: SendSent, Comment: "c", No: 56},
+	}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("results = %+v, want %+v", got, want)
+	}
+}
+
+func TestReconnectFailsPendingSends(t *testing.T) {
+	lv, ps := pendingTestLive(1, 2)
+	lv.expire(ps[0])
+	lv.SendResults()
+
+	lv.failPending()
+	lv.handleChatResult(ChatResult{No: 57}) // first reply on the new socket
+
+	rs := lv.SendResults()
+	if len(rs) != 1 || rs[0].ID != 2 || rs[0].State != SendFailed {
+		for _, r := range rs {
+			t.Logf("%+v", *r)
+		}
+		t.Fatalf("want only #2 failed")
+	}
+	if len(lv.pending) != 0 {
+		t.Errorf("%d sends still pending", len(lv.pending))
+	}
+}
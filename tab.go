@@ -0,0 +1,265 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// tab keeps the state of a broadcast that is not on the screen. The
+// broadcast in front lives in the fields of View; switchTab swaps them.
+type tab struct {
+	live    *Live
+	owned   bool // opened by :open and closed by the view
+	komes   []Chat
+	shown   []int
+	top     int
+	topRow  int
+	ptr     int
+	hidden  int
+	filter  *filter
+	outbox  []*outgoing
+	preload []Chat // logged comments, shown when the tab is first opened
+
+	// received while in the background
+	pending []Chat
+	events  []Event
+}
+
+// tabMsg is something a Live sent, tagged with its tab.
+type tabMsg struct {
+	tab  *tab
+	kome *Chat
+	ev   *Event
+}
+
+// opened is the result of opening a broadcast with :open.
+type opened struct {
+	liveID  string
+	live    *Live
+	history []Chat
+	err     error
+}
+
+// loadLive prepares the broadcast liveID, resuming after the comments
+// logged by an earlier session, which are returned with their users.
+func loadLive(account *Account, repo *UserRepo, log *CommentLog, liveID string) (*Live, []Chat, error) {
+	history, err := log.Load(liveID)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range history {
+		history[i].User = repo.Get(history[i].UserID)
+		if history[i].IsOperator() {
+			history[i].Control = parseControl(history[i].Comment)
+		}
+	}
+
+	lv := NewLive(account, repo, liveID)
+	if err := lv.LoadPlayerStatus(); err != nil {
+		return nil, nil, err
+	}
+	if len(history) > 0 {
+		lv.Resume(history[len(history)-1].No)
+	}
+	return lv, history, nil
+}
+
+// unread counts the comments received while the tab was in the background.
+func (v *View) unread(t *tab) int {
+	n := 0
+	for _, kome := range t.pending {
+		if !v.ng.Match(kome) {
+			n++
+		}
+	}
+	return n
+}
+
+// addTab adds a connected broadcast behind the current one.
+func (v *View) addTab(live *Live, history []Chat, owned bool) {
+	t := &tab{live: live, owned: owned, preload: history}
+	v.tabs = append(v.tabs, t)
+	go v.forward(t)
+}
+
+// forward passes everything the Live of t sends to tabCh
+// until the Live is closed.
+func (v *View) forward(t *tab) {
+	send := func(m tabMsg) bool {
+		select {
+		case v.tabCh <- m:
+			return true
+		case <-t.live.sig:
+			return false
+		}
+	}
+
+	for {
+		select {
+		case <-t.live.sig:
+			return
+		case kome := <-t.live.KomeCh:
+			if !send(tabMsg{tab: t, kome: &kome}) {
+				return
+			}
+		case ev := <-t.live.EventCh:
+			if !send(tabMsg{tab: t, ev: &ev}) {
+				return
+			}
+		case <-t.live.SendCh:
+			for _, r := range t.live.SendResults() {
+				if !send(tabMsg{tab: t, ev: &Event{Type: EventSend, Send: r}}) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// updateTab logs a comment and sends it to the overlay and hooks as it
+// arrives, whichever tab it belongs to; only drawing waits for the tab.
+// The hooks wait for the name of the user like --json does.
+func (v *View) updateTab(m tabMsg) {
+	if m.kome != nil {
+		if v.log != nil {
+			v.log.Save(m.tab.live.LiveID, *m.kome)
+		}
+		v.overlay.Push(m.tab.live.LiveID, *m.kome)
+		if v.hooks != nil {
+			v.hookQ.push(m.tab.live.LiveID, *m.kome)
+			v.releaseHooks()
+		}
+	}
+
+	if m.tab != v.tabs[v.cur] {
+		if m.kome != nil {
+			m.tab.pending = append(m.tab.pending, *m.kome)
+		} else {
+			m.tab.events = append(m.tab.events, *m.ev)
+		}
+		return
+	}
+
+	if m.kome != nil {
+		v.updateKome(*m.kome)
+	} else {
+		v.updateLiveEvent(*m.ev)
+	}
+}
+
+// releaseHooks passes the held comments whose user is ready to the hooks.
+func (v *View) releaseHooks() {
+	for {
+		k, ok := v.hookQ.pop(false)
+		if !ok {
+			return
+		}
+		v.hooks.Push(k.liveID, k.kome)
+	}
+}
+
+// switchTab brings tab i to the front and catches up with what it
+// received in the background.
+func (v *View) switchTab(i int) {
+	if i == v.cur || i < 0 || i >= len(v.tabs) {
+		return
+	}
+
+	cur := v.tabs[v.cur]
+	cur.live, cur.komes, cur.shown = v.live, v.komes, v.shown
+	cur.top, cur.topRow, cur.ptr, cur.hidden = v.top, v.topRow, v.ptr, v.hidden
+	cur.filter, cur.outbox = v.filter, v.outbox
+
+	t := v.tabs[i]
+	v.cur = i
+	v.live, v.komes, v.shown = t.live, t.komes, t.shown
+	v.top, v.topRow, v.ptr, v.hidden = t.top, t.topRow, t.ptr, t.hidden
+	v.filter, v.outbox = t.filter, t.outbox
+	t.komes, t.shown, t.filter, t.outbox = nil, nil, nil, nil
+
+	if t.preload != nil {
+		v.preload(t.preload)
+		t.preload = nil
+	} else {
+		// NG entries may have changed meanwhile
+		v.setFilter(v.filter)
+	}
+
+	for _, kome := range t.pending {
+		v.updateKome(kome)
+	}
+	for _, ev := range t.events {
+		v.updateLiveEvent(ev)
+	}
+	t.pending, t.events = nil, nil
+}
+
+// openTab connects to liveID in the background; the tab is added
+// when it is ready.
+func (v *View) openTab(liveID string) error {
+	if v.live.account == nil {
+		return errors.New("cannot open broadcasts without an account")
+	}
+	for i, t := range v.tabs {
+		if t.live.LiveID == liveID {
+			v.switchTab(i)
+			return nil
+		}
+	}
+	if v.opening[liveID] {
+		return fmt.Errorf("%v is already opening", liveID)
+	}
+	v.opening[liveID] = true
+
+	account, repo, log, interval := v.live.account, v.live.repo, v.log, v.conf.SendInterval
+	go func() {
+		lv, history, err := loadLive(account, repo, log, liveID)
+		if err == nil {
+			lv.SetSendInterval(interval)
+			err = lv.Connect(time.Second * 5)
+		}
+		if err != nil {
+			err = fmt.Errorf("failed to open %v: %v", liveID, err)
+		}
+		v.openCh <- opened{liveID, lv, history, err}
+	}()
+	v.notify("opening "+liveID, false)
+	return nil
+}
+
+func (v *View) updateOpened(o opened) {
+	delete(v.opening, o.liveID)
+	if o.err != nil {
+		v.notify(o.err.Error(), true)
+		return
+	}
+	v.addTab(o.live, o.history, true)
+	v.switchTab(len(v.tabs) - 1)
+	v.notify("opened "+o.live.LiveID, false)
+}
+
+// closeTabs closes the broadcasts opened by :open.
+func (v *View) closeTabs() {
+	for _, t := range v.tabs {
+		if t.owned {
+			t.live.Close()
+		}
+	}
+}
+
+// tabsText lists the tabs for the status bar, with unread counts
+// of the ones in the background.
+func (v *View) tabsText() string {
+	s := ""
+	for i, t := range v.tabs {
+		if i == v.cur {
+			continue
+		}
+		s += fmt.Sprintf(" %d:%s", i+1, t.live.LiveID)
+		if n := v.unread(t); n > 0 {
+			s += fmt.Sprintf("(%d)", n)
+		}
+	}
+	return s
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/mattn/go-runewidth"
 	"github.com/nsf/termbox-go"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
@@ -13,30 +14,82 @@ import (
 const chainThreshold = 500 * 1000 * 1000
 
 type View struct {
-	quit   bool
-	width  int
-	height int
-	top    int
-	ptr    int
-	live   *Live
-	komes  []Chat
-	cmd    []rune
-	prev   int64
-	chain  []rune
-}
-
-func NewView(live *Live) *View {
+	quit    bool
+	width   int
+	height  int
+	top     int
+	topRow  int // rows of the top comment scrolled off the screen
+	ptr     int
+	wrap    bool
+	hscroll int
+	detail  bool
+	live    *Live
+	conf    *Config
+	log     *CommentLog
+	ng      *NGList
+	ngMask  bool
+	hidden  int
+	player  *Player
+	overlay *Overlay
+	hooks   *Hooks
+	hookQ   userWaitQueue // comments waiting for their user before the hooks
+	outbox  []*outgoing
+	komes   []Chat
+	shown   []int
+	filter  *filter
+	search  *regexp.Regexp
+	back    bool
+	edit    lineEditor
+	msg     string
+	msgErr  bool
+	prev    int64
+	chain   []rune
+
+	// broadcasts in tabs; the current one is in the fields above
+	tabs    []*tab
+	cur     int
+	tabCh   chan tabMsg
+	openCh  chan opened
+	opening map[string]bool // liveIDs being opened by :open
+
+	// user history for the detail pane
+	history    []BroadcastSeen
+	historyFor string
+	historyErr error
+}
+
+func NewView(live *Live, conf *Config, log *CommentLog, ng *NGList, hist *History) *View {
 	w, h := termbox.Size()
-	return &View{
+	v := &View{
 		width:  w,
 		height: h,
 		top:    0,
 		ptr:    0,
 		live:   live,
+		conf:   conf,
+		log:    log,
+		ng:     ng,
+		edit:   lineEditor{hist: hist},
+		tabCh:  make(chan tabMsg),
+		openCh: make(chan opened),
+
+		opening: make(map[string]bool),
 	}
+	v.addTab(live, nil, false)
+	return v
+}
+
+// preload shows komes from an earlier session and moves to the last one.
+func (v *View) preload(komes []Chat) {
+	v.komes = komes
+	v.refilter()
+	v.ptr = len(v.shown) - 1
+	v.fixPtr()
 }
 
 func (v *View) Loop() {
+	defer v.closeTabs()
+
 	evCh := make(chan termbox.Event)
 	go func() {
 		for {
@@ -48,16 +101,31 @@ func (v *View) Loop() {
 	v.updateView()
 
 	tick := time.Tick(time.Second / 2)
+	var logErrCh chan error
+	if v.log != nil {
+		logErrCh = v.log.ErrCh
+	}
 	for {
 		select {
 		case <-tick:
+			v.pruneOutbox()
+			v.releaseHooks()
 		case ev := <-evCh:
 			if ev.Type == termbox.EventKey && ev.Key == termbox.KeyCtrlC {
 				return
 			}
 			v.updateEvent(ev)
-		case kome := <-v.live.KomeCh:
-			v.updateKome(kome)
+		case m := <-v.tabCh:
+			v.updateTab(m)
+		case o := <-v.openCh:
+			v.updateOpened(o)
+		case err := <-logErrCh:
+			v.notify(err.Error(), true)
+		case user := <-v.live.repo.UpdateCh:
+			v.overlay.PushUser(user)
+			v.hookQ.rename(user)
+			v.releaseHooks()
+			v.updateUser(user)
 		}
 
 		if v.quit {
@@ -86,75 +154,150 @@ func (v *View) updateEvent(ev termbox.Event) {
 			v.prev = now
 		}
 
-		if len(v.cmd) != 0 {
+		if v.edit.active() {
 			// cmd now
 			switch ev.Key {
 			case termbox.KeyEsc:
-				v.cmd = nil
+				v.edit.reset()
 			case termbox.KeyEnter:
 				v.execCommand()
-			case termbox.KeyBackspace, termbox.KeyBackspace2:
-				if len(v.cmd) > 1 || v.cmd[0] != 'i' {
-					v.cmd = v.cmd[0 : len(v.cmd)-1]
-				}
-			case termbox.KeySpace:
-				v.cmd = append(v.cmd, ' ')
+			case termbox.KeyTab:
+				v.complete()
 			default:
-				if ev.Ch != 0 {
-					v.cmd = append(v.cmd, ev.Ch)
-				}
+				v.edit.handle(ev)
 			}
 			return
 		}
 
-		switch ev.Ch {
-		case 'q':
+		// a count before the key repeats j/k and gives the number for G
+		action, count := v.conf.lookup(v.chain)
+		step := count
+		if step == 0 {
+			step = 1
+		}
+		switch action {
+		case "quit":
 			v.quit = true
-		case 'i', ':':
-			v.cmd = append(v.cmd, ev.Ch)
-		case 'j':
-			v.ptr++
+		case "insert":
+			v.startCmd('i')
+		case "command":
+			v.startCmd(':')
+		case "search":
+			v.startCmd('/')
+		case "search_back":
+			v.startCmd('?')
+		case "down":
+			v.down(step)
+		case "up":
+			v.up(step)
+		case "bottom":
+			// 22G -> jump to 22kome
+			if count > 0 {
+				v.jumpTo(count)
+				break
+			}
+			v.ptr = len(v.shown) - 1
 			v.fixPtr()
-		case 'k':
-			v.ptr--
+		case "top":
+			v.ptr = 0
 			v.fixPtr()
-		case 'G':
-			c := string(v.chain)
-			if len(c) > 1 && c[len(c)-1] == 'G' {
-				n, err := strconv.ParseInt(c[0:len(c)-1], 10, 32)
-				if err == nil {
-					v.jumpTo(int(n))
-					break
-				}
+		case "next":
+			v.searchNext(v.back)
+		case "prev":
+			v.searchNext(!v.back)
+		case "detail":
+			v.detail = !v.detail
+			v.fixPtr()
+		case "wrap":
+			atBottom := v.calcEnd() == len(v.shown)
+			v.wrap = !v.wrap
+			v.hscroll = 0
+			v.topRow = 0
+			if atBottom {
+				v.followBottom()
 			}
-
-			v.ptr = len(v.komes) - 1
 			v.fixPtr()
-		case 'g':
-			if string(v.chain) == "gg" {
-				v.ptr = 0
-				v.fixPtr()
+		case "left":
+			v.hscroll -= 4 * step
+			if v.hscroll < 0 {
+				v.hscroll = 0
+			}
+		case "right":
+			if !v.wrap {
+				v.hscroll += 4 * step
+			}
+		case "pause":
+			if v.player != nil {
+				v.player.TogglePause()
+			}
+		case "speed":
+			if v.player != nil {
+				v.player.NextSpeed()
+			}
+		case "next_tab":
+			// 3gt -> go to the third tab
+			if count > 0 {
+				v.switchTab(count - 1)
+				break
+			}
+			v.switchTab((v.cur + 1) % len(v.tabs))
+		case "prev_tab":
+			v.switchTab((v.cur + len(v.tabs) - step%len(v.tabs)) % len(v.tabs))
+		case "hooks":
+			if v.hooks == nil {
+				v.notify("no hooks in config.json", true)
+			} else if v.hooks.Toggle() {
+				v.notify("hooks on", false)
+			} else {
+				v.notify("hooks off", false)
 			}
 		}
 	}
 }
 
-func (v *View) calcEnd() int {
+// startCmd enters command mode; mode is the first rune of cmd
+// and tells what Enter does with the rest.
+func (v *View) startCmd(mode rune) {
+	v.msg = ""
+	v.edit.start(mode)
+}
+
+func (v *View) listHeight() int {
 	h := v.height - 2
+	if v.detailShown() {
+		h -= detailHeight
+	}
+	h -= v.outboxRows()
 	if h < 1 {
 		h = 1
 	}
+	return h
+}
 
-	end := v.top + h
-	if end > len(v.komes) {
-		end = len(v.komes)
+// calcEnd returns the index after the last comment that fits on
+// the screen from top. The first one is always counted, even if it
+// takes more rows than there are.
+func (v *View) calcEnd() int {
+	h := v.listHeight()
+	_, _, indent := v.columns()
+
+	end := v.top
+	used := -v.topRow
+	for end < len(v.shown) {
+		r := v.rowsOf(end, indent)
+		if used+r > h && end > v.top {
+			break
+		}
+		used += r
+		end++
 	}
 	return end
 }
 
 func (v *View) fixPtr() {
-	if len(v.komes) == 0 {
+	if len(v.shown) == 0 {
 		v.top = 0
+		v.topRow = 0
 		v.ptr = 0
 		return
 	}
@@ -162,36 +305,211 @@ func (v *View) fixPtr() {
 	if v.ptr < 0 {
 		v.ptr = 0
 	}
-	if v.ptr >= len(v.komes) {
-		v.ptr = len(v.komes) - 1
+	if v.ptr >= len(v.shown) {
+		v.ptr = len(v.shown) - 1
+	}
+	if v.top >= len(v.shown) {
+		v.top = len(v.shown) - 1
+	}
+	_, _, indent := v.columns()
+	if r := v.rowsOf(v.top, indent); v.topRow >= r {
+		v.topRow = r - 1
 	}
 
 	if v.ptr < v.top {
 		v.top = v.ptr
+		v.topRow = 0
 		return
 	}
 
-	end := v.calcEnd()
-	if v.ptr >= end {
-		v.top += v.ptr - end + 1
-		return
+	if v.ptr > v.top && v.ptr >= v.calcEnd() {
+		if v.rowsOf(v.ptr, indent) < v.listHeight() {
+			v.scrollTo(v.ptr)
+		}
+		// the columns of the new screen may be wider, leaving less room
+		// to wrap; ptr must not end up below it
+		if v.ptr > v.top && v.ptr >= v.calcEnd() {
+			v.top = v.ptr
+			v.topRow = 0
+		}
+	}
+}
+
+// scrollTo moves top so that shown[i] ends on the last row, walking
+// backward from i. The top comment may be cut to fill the screen.
+func (v *View) scrollTo(i int) {
+	h := v.listHeight()
+
+	// the comments that can be on the screen with i at the bottom
+	from := i - h + 1
+	if from < 0 {
+		from = 0
+	}
+	_, _, indent := v.columnsFrom(from)
+
+	top, used := i, v.rowsOf(i, indent)
+	for top > 0 && used < h {
+		top--
+		used += v.rowsOf(top, indent)
+	}
+	v.top = top
+	v.topRow = 0
+	if used > h {
+		v.topRow = used - h
+	}
+}
+
+// down moves ptr step comments down. A comment taller than the
+// screen scrolls by rows before ptr moves on.
+func (v *View) down(step int) {
+	for n := 0; n < step; n++ {
+		if v.ptrEndsBelow() {
+			v.scrollRow()
+		} else {
+			v.ptr++
+		}
+		v.fixPtr()
+	}
+}
+
+// up moves ptr step comments up, scrolling back to the first row of the
+// comment at ptr first.
+func (v *View) up(step int) {
+	for n := 0; n < step; n++ {
+		if v.ptr == v.top && v.topRow > 0 {
+			v.topRow--
+		} else {
+			v.ptr--
+		}
+		v.fixPtr()
+	}
+}
+
+// ptrEndsBelow reports whether the comment at ptr goes past the last row.
+func (v *View) ptrEndsBelow() bool {
+	if !v.wrap || v.ptr < v.top {
+		return false
+	}
+	_, _, indent := v.columns()
+	used := -v.topRow
+	for i := v.top; i <= v.ptr; i++ {
+		used += v.rowsOf(i, indent)
+		if used > v.listHeight() {
+			return true
+		}
+	}
+	return false
+}
+
+// scrollRow scrolls the list by one row.
+func (v *View) scrollRow() {
+	_, _, indent := v.columns()
+	v.topRow++
+	if v.topRow >= v.rowsOf(v.top, indent) && v.top < len(v.shown)-1 {
+		v.top++
+		v.topRow = 0
+	}
+}
+
+// columns returns the format of the number column, the width of the
+// user name column and the column comments start at, for the rows from top.
+func (v *View) columns() (string, int, int) {
+	return v.columnsFrom(v.top)
+}
+
+// columnsFrom is columns for the rows from shown[top].
+func (v *View) columnsFrom(top int) (string, int, int) {
+	end := top + v.listHeight()
+	if end > len(v.shown) {
+		end = len(v.shown)
+	}
+	if end <= top {
+		return "%d", 0, 0
+	}
+
+	last := v.komes[v.shown[end-1]]
+	noWidth := len(strconv.Itoa(last.No))
+	nameWidth := 0
+	for _, i := range v.shown[top:end] {
+		if l := stringWidth(v.komes[i].User.Name); l > nameWidth {
+			nameWidth = l
+		}
+	}
+
+	indent := noWidth + 1 + len(v.elapsed(last)) + 1 + nameWidth + 1
+	return fmt.Sprintf("%%0%dd", noWidth), nameWidth, indent
+}
+
+// rowsOf returns how many rows shown[i] takes when comments start at indent.
+func (v *View) rowsOf(i, indent int) int {
+	return len(v.commentRows(v.displayText(v.komes[v.shown[i]]), indent))
+}
+
+// commentRows splits comment into the rows it is drawn on when
+// comments start at indent, as byte ranges of comment.
+func (v *View) commentRows(comment string, indent int) [][2]int {
+	w := v.width - indent
+	if !v.wrap || w < 2 {
+		return [][2]int{{0, len(comment)}}
+	}
+	return wrapText(comment, w)
+}
+
+// wrapText breaks s into rows of at most w columns and at every '\n',
+// which is left out of the rows.
+func wrapText(s string, w int) [][2]int {
+	var rows [][2]int
+	start, x := 0, 0
+	for p, c := range s {
+		if c == '\n' {
+			rows = append(rows, [2]int{start, p})
+			start, x = p+1, 0
+			continue
+		}
+		cw := width(c)
+		if x+cw > w && x > 0 {
+			rows = append(rows, [2]int{start, p})
+			start, x = p, 0
+		}
+		x += cw
+	}
+	if start == len(s) && len(rows) > 0 {
+		// nothing after a trailing '\n'
+		return rows
 	}
+	return append(rows, [2]int{start, len(s)})
+}
+
+// displayText is commentText, masked if kome is NG and ngMask is set.
+func (v *View) displayText(kome Chat) string {
+	if v.ngMask && v.ng.Match(kome) {
+		return "*** NG ***"
+	}
+	return commentText(kome)
+}
+
+// elapsed formats the time of kome since the broadcast started.
+func (v *View) elapsed(kome Chat) string {
+	st := time.Unix(v.live.Status.Stream.StartTime, 0)
+	dif := time.Unix(kome.Date, 0).Sub(st)
+	return fmt.Sprintf("%02d:%02d", int(dif.Minutes()), int(dif.Seconds())%60)
 }
 
 func (v *View) jumpTo(n int) {
-	i := sort.Search(len(v.komes), func(i int) bool { return v.komes[i].No >= n })
-	if i < len(v.komes) && v.komes[i].No == n {
+	i := sort.Search(len(v.shown), func(i int) bool { return v.komes[v.shown[i]].No >= n })
+	if i < len(v.shown) && v.komes[v.shown[i]].No == n {
 		v.ptr = i
 		v.fixPtr()
 	}
 }
 
 func (v *View) execCommand() {
-	defer func() {
-		v.cmd = nil
-	}()
+	cmd := v.edit.String()
+	v.edit.reset()
 
-	cmd := string(v.cmd)
+	if err := v.edit.hist.Add(cmd); err != nil {
+		v.notify(err.Error(), true)
+	}
 
 	// quit
 	if cmd == ":q" {
@@ -202,14 +520,94 @@ func (v *View) execCommand() {
 	// send 184 kome
 	if strings.HasPrefix(cmd, ":184 ") {
 		comment := cmd[5:]
-		v.live.SendKome(comment, true)
+		if _, err := v.live.SendKome(comment, MailCommand{Anonymous: true}); err != nil {
+			v.notify(err.Error(), true)
+		}
+		return
+	}
+
+	// :send red big hoge -> send with mail commands
+	if cmd == ":send" || strings.HasPrefix(cmd, ":send ") {
+		mail, comment := parseMailPrefix(cmd[5:])
+		if comment == "" {
+			v.notify("empty comment", true)
+			return
+		}
+		if _, err := v.live.SendKome(comment, mail); err != nil {
+			v.notify(err.Error(), true)
+		}
 		return
 	}
 
-	// send raw kome
+	// send raw kome, "[red big] hoge" sends with mail commands
 	if strings.HasPrefix(cmd, "i") {
-		comment := cmd[1:]
-		v.live.SendKome(comment, false)
+		mail, comment := splitMailBrackets(cmd[1:])
+		if _, err := v.live.SendKome(comment, mail); err != nil {
+			v.notify(err.Error(), true)
+		}
+		return
+	}
+
+	// :cancel [ID] -> take a comment out of the send queue
+	if cmd == ":cancel" || strings.HasPrefix(cmd, ":cancel ") {
+		if err := v.cancelSend(strings.TrimSpace(cmd[7:])); err != nil {
+			v.notify(err.Error(), true)
+		}
+		return
+	}
+
+	// :open lv123 -> watch another broadcast in a new tab
+	if cmd == ":open" || strings.HasPrefix(cmd, ":open ") {
+		liveID := liveIDReg.FindString(cmd[5:])
+		if liveID == "" {
+			v.notify("usage: :open lv***", true)
+			return
+		}
+		if err := v.openTab(liveID); err != nil {
+			v.notify(err.Error(), true)
+		}
+		return
+	}
+
+	// :rename name -> kotehan for the user of the selected row
+	if cmd == ":rename" || strings.HasPrefix(cmd, ":rename ") {
+		if len(v.shown) == 0 {
+			v.notify("no comment selected", true)
+			return
+		}
+		id := v.komes[v.shown[v.ptr]].UserID
+		if err := v.live.repo.SetKotehan(id, strings.TrimSpace(cmd[7:])); err != nil {
+			v.notify(err.Error(), true)
+		}
+		return
+	}
+
+	// NG users, words and regexes
+	if strings.HasPrefix(cmd, ":ng") {
+		if err := v.execNG(cmd[1:]); err != nil {
+			v.notify(err.Error(), true)
+		}
+		return
+	}
+
+	// /pattern, ?pattern -> search forward, backward
+	if cmd[0] == '/' || cmd[0] == '?' {
+		v.startSearch(cmd[1:], cmd[0] == '?')
+		return
+	}
+
+	// filter the list
+	if cmd == ":nofilter" {
+		v.setFilter(nil)
+		return
+	}
+	if cmd == ":filter" || strings.HasPrefix(cmd, ":filter ") {
+		f, err := v.parseFilter(strings.TrimSpace(cmd[7:]))
+		if err != nil {
+			v.notify(err.Error(), true)
+			return
+		}
+		v.setFilter(f)
 		return
 	}
 
@@ -220,183 +618,238 @@ func (v *View) execCommand() {
 	}
 }
 
+// notify shows msg in the command line until the next command starts.
+func (v *View) notify(msg string, isErr bool) {
+	v.msg = msg
+	v.msgErr = isErr
+}
+
+func (v *View) updateLiveEvent(ev Event) {
+	switch ev.Type {
+	case EventEnded:
+		v.notify("broadcast ended", false)
+	case EventSend:
+		v.updateOutbox(ev.Send)
+		switch ev.Send.State {
+		case SendFailed:
+			v.notify(fmt.Sprintf("failed to send %q: %s", ev.Send.Comment, ev.Send.Error), true)
+		case SendSent:
+			v.notify(fmt.Sprintf("sent %q as No.%d", ev.Send.Comment, ev.Send.No), false)
+		}
+	}
+}
+
 func (v *View) updateKome(kome Chat) {
-	if len(v.komes) == 0 {
+	v.komes = append(v.komes, kome)
+	if !v.accept(kome) {
+		return
+	}
+
+	// keep following new comments while the last one is on the screen
+	atBottom := v.calcEnd() == len(v.shown)
+	v.shown = append(v.shown, len(v.komes)-1)
+	if len(v.shown) == 1 {
 		v.top = 0
+		v.topRow = 0
 		v.ptr = 0
-		v.komes = append(v.komes, kome)
 		return
 	}
 
-	end := v.calcEnd()
-	if end == len(v.komes) {
-		h := v.height - 2
-		if h < 1 {
-			h = 1
-		}
-		if end-v.top+1 > h {
-			v.top++
-			if v.ptr < v.top {
-				v.ptr = v.top
-			}
-		}
+	if atBottom {
+		v.followBottom()
 	}
+}
 
-	v.komes = append(v.komes, kome)
+// followBottom scrolls until the last comment is on the screen.
+func (v *View) followBottom() {
+	if len(v.shown) == 0 {
+		return
+	}
+	v.scrollTo(len(v.shown) - 1)
+	if v.ptr < v.top {
+		v.ptr = v.top
+	}
+}
+
+func (v *View) updateUser(up UserUpdate) {
+	renameUser(v.komes, up)
+	for _, t := range v.tabs {
+		renameUser(t.komes, up)
+		renameUser(t.preload, up)
+		renameUser(t.pending, up)
+	}
+}
+
+func renameUser(komes []Chat, up UserUpdate) {
+	for i := range komes {
+		if komes[i].UserID == up.UserID {
+			komes[i].User = up.User
+		}
+	}
 }
 
 func (v *View) updateView() {
 	termbox.HideCursor()
-	nowCmd := len(v.cmd) != 0
+	nowCmd := v.edit.active()
 
 	// line view
-	if len(v.komes) > 0 && v.height > 2 {
+	if v.height > 2 {
+		h := v.listHeight()
 		end := v.calcEnd()
-
-		noPadFormat := func() string {
-			last := v.komes[end-1]
-			noStr := fmt.Sprintf("%d", last.No)
-			return fmt.Sprintf("%%0%dd", len(noStr))
-		}()
-		maxUserNameLen := func() int {
-			maxLen := 0
-			for _, kome := range v.komes[v.top:end] {
-				l := stringWidth(kome.User.Name)
-				if l > maxLen {
-					maxLen = l
-				}
-			}
-			return maxLen
-		}()
+		noPadFormat, maxUserNameLen, indent := v.columns()
 
 		y := 0
-		for i := v.top; i < end; i++ {
+		for i := v.top; i < end && y < h; i++ {
+			kome := v.komes[v.shown[i]]
 			bg := termbox.ColorDefault
 			if i == v.ptr {
-				bg = termbox.ColorGreen
+				bg = v.conf.Theme.Selection
 			}
 
-			x := 0
-			{
-				// no
-				fg := termbox.ColorBlue
-				if i == v.ptr {
-					fg = termbox.ColorDefault
-				}
-
-				no := fmt.Sprintf(noPadFormat, v.komes[i].No)
-				for _, c := range no {
-					termbox.SetCell(x, y, c, fg, bg)
-					x++
-				}
+			// comment, or what an operator command means
+			fg := termbox.ColorDefault
+			comment := v.displayText(kome)
+			if kome.Control != nil {
+				fg = termbox.ColorMagenta | termbox.AttrBold
+			} else if i != v.ptr && !(v.ngMask && v.ng.Match(kome)) {
+				fg = kome.MailCommand().Attr()
 			}
 
-			termbox.SetCell(x, y, ' ', termbox.ColorDefault, bg)
-			x++
-
-			{
-				//time
-				fg := termbox.ColorYellow
-				if i == v.ptr {
-					fg = termbox.ColorDefault
-				}
-
-				st := time.Unix(v.live.Status.Stream.StartTime, 0)
-				tm := time.Unix(v.komes[i].Date, 0)
-				dif := tm.Sub(st)
-				line := fmt.Sprintf("%02d:%02d", int(dif.Minutes()), int(dif.Seconds())%60)
-				for _, c := range line {
-					termbox.SetCell(x, y, c, fg, bg)
-					x++
-				}
+			var hits [][]int
+			if v.search != nil {
+				hits = v.search.FindAllStringIndex(comment, -1)
 			}
 
-			termbox.SetCell(x, y, ' ', termbox.ColorDefault, bg)
-			x++
-
-			{
-				// userName
-				fg := termbox.ColorGreen
-				userName := v.komes[i].User.Name
-
-				if !v.komes[i].User.IsRawUser {
-					fg = termbox.ColorYellow
+			rows := v.commentRows(comment, indent)
+			first := 0
+			if i == v.top {
+				first = v.topRow
+			}
+			for r := first; r < len(rows) && y < h; r++ {
+				x := 0
+				if r == 0 {
+					x = v.drawHeader(i, kome, y, bg, noPadFormat, maxUserNameLen)
 				}
-				if i == v.ptr {
-					fg = termbox.ColorDefault
+				for ; x < indent; x++ {
+					termbox.SetCell(x, y, ' ', termbox.ColorDefault, bg)
 				}
 
-				l := 0
-				for _, c := range userName {
-					termbox.SetCell(x, y, c, fg, bg)
-					w := width(c)
-					x += w
-					l += w
-				}
-				for ; l < maxUserNameLen; l++ {
-					termbox.SetCell(x, y, ' ', fg, bg)
-					x++
+				if i == v.ptr && r == first && !nowCmd {
+					termbox.SetCursor(v.width-1, y)
 				}
-			}
-
-			termbox.SetCell(x, y, ' ', termbox.ColorDefault, bg)
-			x++
 
-			for _, c := range v.komes[i].Comment {
-				termbox.SetCell(x, y, c, termbox.ColorDefault, bg)
-				x += width(c)
-			}
-			for ; x < v.width; x++ {
-				termbox.SetCell(x, y, ' ', termbox.ColorDefault, bg)
-			}
+				// col counts columns of the comment, for horizontal scrolling
+				col := 0
+				start, stop := rows[r][0], rows[r][1]
+				for p, c := range comment[start:stop] {
+					p += start
+					if c == '\n' {
+						c = ' '
+					}
+					cw := width(c)
+					if !v.wrap && col < v.hscroll {
+						col += cw
+						continue
+					}
+					if x+cw > v.width {
+						break
+					}
+
+					cfg, cbg := fg, bg
+					for len(hits) > 0 && hits[0][1] <= p {
+						hits = hits[1:]
+					}
+					if len(hits) > 0 && hits[0][0] <= p {
+						cfg, cbg = termbox.ColorBlack, termbox.ColorYellow
+					}
+					termbox.SetCell(x, y, c, cfg, cbg)
+					x += cw
+					col += cw
+				}
 
-			if i == v.ptr && !nowCmd {
-				termbox.SetCursor(v.width-1, y)
+				for ; x < v.width; x++ {
+					termbox.SetCell(x, y, ' ', termbox.ColorDefault, bg)
+				}
+				y++
 			}
-			y++
 		}
-		for ; y < v.height-2; y++ {
+		for ; y < h; y++ {
 			for x := 0; x < v.width; x++ {
 				termbox.SetCell(x, y, ' ', termbox.ColorDefault, termbox.ColorDefault)
 			}
 		}
 	}
 
-	// info view
-	if v.height > 1 {
+	if v.detailShown() {
+		v.drawDetail(v.listHeight())
+	}
+	if v.outboxRows() > 0 {
+		v.drawOutbox(v.height - 2 - v.outboxRows())
+	}
+
+	// info view, or completion candidates while completing
+	if v.height > 1 && v.edit.completing() {
+		v.drawCandidates(v.height - 2)
+	} else if v.height > 1 {
 		left := fmt.Sprintf("[%s] %s", v.live.LiveID, v.live.Status.Stream.Title)
+		if len(v.tabs) > 1 {
+			left = fmt.Sprintf("[%d:%s]%s %s", v.cur+1, v.live.LiveID, v.tabsText(), v.live.Status.Stream.Title)
+		}
+		if v.filter != nil {
+			left += fmt.Sprintf(" [filter: %s]", v.filter.desc)
+		}
 
 		par := 0
-		if len(v.komes) > 0 {
-			par = v.calcEnd() * 100 / len(v.komes)
+		if len(v.shown) > 0 {
+			par = v.calcEnd() * 100 / len(v.shown)
 		}
 
 		start := time.Unix(v.live.Status.Stream.StartTime, 0)
-		dif := time.Now().Sub(start)
+		now := time.Now()
+		state := v.live.State().String()
+		if v.player != nil {
+			// the clock of a replay is the last comment received
+			now = start
+			if len(v.komes) > 0 {
+				now = time.Unix(v.komes[len(v.komes)-1].Date, 0)
+			}
+			if v.live.State() != StateEnded {
+				state = v.player.String()
+			} else {
+				state = "replay ended"
+			}
+		}
+		dif := now.Sub(start)
 
-		right := fmt.Sprintf("%02d:%02d | %d%%", int(dif.Minutes()), int(dif.Seconds())%60, par)
+		right := fmt.Sprintf("%s | %02d:%02d | %d%%", state, int(dif.Minutes()), int(dif.Seconds())%60, par)
+		if v.hidden > 0 {
+			right = fmt.Sprintf("NG %d | %s", v.hidden, right)
+		}
+		if v.hooks != nil && !v.hooks.Enabled() {
+			right = "hooks off | " + right
+		}
 
 		y := v.height - 2
 		x := 0
 		for _, c := range left {
-			termbox.SetCell(x, y, c, termbox.ColorDefault, termbox.ColorBlue)
+			termbox.SetCell(x, y, c, termbox.ColorDefault, v.conf.Theme.StatusBar)
 			x += width(c)
 		}
 
 		mid := v.width - x - len(right)
 		if mid > 0 {
 			for i := 0; i < mid; i++ {
-				termbox.SetCell(x, y, ' ', termbox.ColorDefault, termbox.ColorBlue)
+				termbox.SetCell(x, y, ' ', termbox.ColorDefault, v.conf.Theme.StatusBar)
 				x++
 			}
 			for _, c := range right {
-				termbox.SetCell(x, y, c, termbox.ColorDefault, termbox.ColorBlue)
+				termbox.SetCell(x, y, c, termbox.ColorDefault, v.conf.Theme.StatusBar)
 				x++
 			}
 		}
 
 		for ; x < v.width; x++ {
-			termbox.SetCell(x, y, ' ', termbox.ColorDefault, termbox.ColorBlue)
+			termbox.SetCell(x, y, ' ', termbox.ColorDefault, v.conf.Theme.StatusBar)
 		}
 	}
 
@@ -405,15 +858,37 @@ func (v *View) updateView() {
 		y := v.height - 1
 		x := 0
 
-		cmd := string(v.cmd)
-		if len(cmd) > 0 && cmd[0] == 'i' {
-			cmd = "send: " + cmd[1:]
+		prompt := string(v.edit.mode)
+		if v.edit.mode == 'i' {
+			prompt = "send: "
+		}
+		line := []rune(prompt + string(v.edit.buf))
+		cursor := len(prompt) + v.edit.pos
+		fg := termbox.ColorGreen
+		if !nowCmd {
+			line = []rune(v.msg)
+			cursor = 0
+			if v.msgErr {
+				fg = termbox.ColorRed
+			}
+		}
+
+		// scroll a long line so the cursor stays on the screen
+		start := 0
+		for start < cursor && stringWidth(string(line[start:cursor])) >= v.width {
+			start++
 		}
-		for _, c := range cmd {
-			termbox.SetCell(x, y, c, termbox.ColorGreen, termbox.ColorDefault)
+		for i, c := range line[start:] {
+			if start+i == cursor && nowCmd {
+				termbox.SetCursor(x, y)
+			}
+			if x+width(c) > v.width {
+				break
+			}
+			termbox.SetCell(x, y, c, fg, termbox.ColorDefault)
 			x += width(c)
 		}
-		if nowCmd {
+		if cursor >= len(line) && nowCmd {
 			termbox.SetCursor(x, y)
 		}
 		for ; x < v.width; x++ {
@@ -424,6 +899,70 @@ func (v *View) updateView() {
 	termbox.Flush()
 }
 
+// drawHeader draws the number, time and user name of shown[i] on row y
+// and returns the column after them.
+func (v *View) drawHeader(i int, kome Chat, y int, bg termbox.Attribute, noPadFormat string, maxUserNameLen int) int {
+	x := 0
+	{
+		// no
+		fg := v.conf.Theme.No
+		if i == v.ptr {
+			fg = termbox.ColorDefault
+		}
+
+		no := fmt.Sprintf(noPadFormat, kome.No)
+		for _, c := range no {
+			termbox.SetCell(x, y, c, fg, bg)
+			x++
+		}
+	}
+
+	termbox.SetCell(x, y, ' ', termbox.ColorDefault, bg)
+	x++
+
+	{
+		//time
+		fg := v.conf.Theme.Time
+		if i == v.ptr {
+			fg = termbox.ColorDefault
+		}
+
+		for _, c := range v.elapsed(kome) {
+			termbox.SetCell(x, y, c, fg, bg)
+			x++
+		}
+	}
+
+	termbox.SetCell(x, y, ' ', termbox.ColorDefault, bg)
+	x++
+
+	{
+		// userName
+		fg := v.conf.Theme.User
+		userName := kome.User.Name
+
+		if !kome.User.IsRawUser {
+			fg = v.conf.Theme.User184
+		}
+		if i == v.ptr {
+			fg = termbox.ColorDefault
+		}
+
+		l := 0
+		for _, c := range userName {
+			termbox.SetCell(x, y, c, fg, bg)
+			w := width(c)
+			x += w
+			l += w
+		}
+		for ; l < maxUserNameLen; l++ {
+			termbox.SetCell(x, y, ' ', fg, bg)
+			x++
+		}
+	}
+	return x
+}
+
 func stringWidth(s string) int {
 	w := 0
 	for _, c := range s {
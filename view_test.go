@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// testView is a wrapping view of 40 comments, long enough that the
+// number column gets wider at No.10.
+func testView(width, height, length int) *View {
+	v := &View{width: width, height: height, wrap: true, live: &Live{}, conf: &Config{}}
+	for i := 0; i < 40; i++ {
+		v.updateKome(Chat{No: i + 1, Comment: strings.Repeat("x", length*(i%3+1)), UserID: "1", User: User{Name: "u"}})
+	}
+	return v
+}
+
+func (v *View) ptrVisible() bool {
+	return v.ptr == v.top || (v.ptr > v.top && v.ptr < v.calcEnd())
+}
+
+func TestScrollingKeepsPtrOnScreen(t *testing.T) {
+	for width := 8; width < 24; width++ {
+		for height := 4; height < 12; height++ {
+			for _, length := range []int{1, 5, 30} {
+				v := testView(width, height, length)
+				v.ptr, v.top, v.topRow = 0, 0, 0
+				v.fixPtr()
+
+				// j until the last comment, which a stuck view never reaches
+				for n := 0; n < 10000 && v.ptr < len(v.shown)-1; n++ {
+					v.down(1)
+					if !v.ptrVisible() {
+						t.Fatalf("%dx%d, length %d: ptr %d is off the screen from %d", width, height, length, v.ptr, v.top)
+					}
+				}
+				if v.ptr != len(v.shown)-1 {
+					t.Fatalf("%dx%d, length %d: j stopped at %d", width, height, length, v.ptr)
+				}
+
+				for n := 0; n < 10000 && (v.ptr > 0 || v.topRow > 0); n++ {
+					v.up(1)
+					if !v.ptrVisible() {
+						t.Fatalf("%dx%d, length %d: ptr %d is off the screen from %d", width, height, length, v.ptr, v.top)
+					}
+				}
+				if v.ptr != 0 || v.top != 0 {
+					t.Fatalf("%dx%d, length %d: k stopped at %d", width, height, length, v.ptr)
+				}
+			}
+		}
+	}
+}
+
+func TestWrapText(t *testing.T) {
+	rows := wrapText("ab\ncdefg\n", 3)
+	want := [][2]int{{0, 2}, {3, 6}, {6, 8}}
+	if len(rows) != len(want) {
+		t.Fatalf("rows = %v, want %v", rows, want)
+	}
+	for i := range want {
+		if rows[i] != want[i] {
+			t.Fatalf("rows = %v, want %v", rows, want)
+		}
+	}
+}